@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Aminochka4/Golang/final-project/pkg/my-project/model"
+	"github.com/Aminochka4/Golang/final-project/pkg/my-project/validator"
+	"github.com/gorilla/mux"
+)
+
+// listRolesHandler returns every role with the permissions it grants.
+func (app *application) listRolesHandler(w http.ResponseWriter, r *http.Request) {
+	roles, err := app.models.Permissions.GetRoles()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"roles": roles}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getUserPermissionsHandler returns the roles of a user, the permissions granted to them
+// directly, and all the permissions they end up with.
+func (app *application) getUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.administeredUser(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeUserPermissions(w, r, user)
+}
+
+// grantRoleHandler gives a role to a user.
+func (app *application) grantRoleHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.administeredUser(w, r)
+	if !ok {
+		return
+	}
+
+	role := mux.Vars(r)["role"]
+	if !validator.In(role, model.RoleMember, model.RoleModerator, model.RoleAdmin) {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err := app.models.Permissions.AddRolesForUser(user.Id, role)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.logger.PrintInfo("role granted", map[string]string{
+		"role":    role,
+		"userId":  strconv.FormatInt(user.Id, 10),
+		"grantor": strconv.FormatInt(app.contextGetUser(r).Id, 10),
+	})
+
+	app.writeUserPermissions(w, r, user)
+}
+
+// revokeRoleHandler takes a role from a user. Admins can't take the admin role from themselves,
+// so that there is always someone left to manage roles.
+func (app *application) revokeRoleHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.administeredUser(w, r)
+	if !ok {
+		return
+	}
+
+	role := mux.Vars(r)["role"]
+	if role == model.RoleAdmin && user.Id == app.contextGetUser(r).Id {
+		app.errorResponse(w, r, http.StatusConflict, "you can't revoke your own admin role")
+		return
+	}
+
+	err := app.models.Permissions.RemoveRoleForUser(user.Id, role)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.logger.PrintInfo("role revoked", map[string]string{
+		"role":    role,
+		"userId":  strconv.FormatInt(user.Id, 10),
+		"revoker": strconv.FormatInt(app.contextGetUser(r).Id, 10),
+	})
+
+	app.writeUserPermissions(w, r, user)
+}
+
+// grantPermissionHandler grants a permission to a user directly, on top of their roles.
+func (app *application) grantPermissionHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.administeredUser(w, r)
+	if !ok {
+		return
+	}
+
+	permission := mux.Vars(r)["permission"]
+
+	exists, err := app.models.Permissions.Exists(permission)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !exists {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Permissions.AddForUser(user.Id, permission)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.logger.PrintInfo("permission granted", map[string]string{
+		"permission": permission,
+		"userId":     strconv.FormatInt(user.Id, 10),
+		"grantor":    strconv.FormatInt(app.contextGetUser(r).Id, 10),
+	})
+
+	app.writeUserPermissions(w, r, user)
+}
+
+// revokePermissionHandler revokes a permission granted to a user directly. Permissions the user
+// has through a role stay until the role is revoked.
+func (app *application) revokePermissionHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.administeredUser(w, r)
+	if !ok {
+		return
+	}
+
+	permission := mux.Vars(r)["permission"]
+
+	err := app.models.Permissions.RemoveForUser(user.Id, permission)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.logger.PrintInfo("permission revoked", map[string]string{
+		"permission": permission,
+		"userId":     strconv.FormatInt(user.Id, 10),
+		"revoker":    strconv.FormatInt(app.contextGetUser(r).Id, 10),
+	})
+
+	app.writeUserPermissions(w, r, user)
+}
+
+// administeredUser looks up the user named by the "userId" URL parameter. If there is no such
+// user, the matching error response is sent and false is returned.
+func (app *application) administeredUser(w http.ResponseWriter, r *http.Request) (*model.User, bool) {
+	id, err := app.readIDParam(r, "userId")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	user, err := app.models.Users.GetById(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return user, true
+}
+
+// writeUserPermissions responds with the roles and permissions of a user.
+func (app *application) writeUserPermissions(w http.ResponseWriter, r *http.Request, user *model.User) {
+	roles, err := app.models.Permissions.GetRolesForUser(user.Id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	direct, err := app.models.Permissions.GetDirectForUser(user.Id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	effective, err := app.models.Permissions.GetAllForUser(user.Id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{
+		"userId":      user.Id,
+		"roles":       roles,
+		"permissions": direct,
+		"effective":   effective,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
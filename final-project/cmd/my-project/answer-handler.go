@@ -1,9 +1,12 @@
 package main
 
 import (
+	"errors"
 	"github.com/Aminochka4/Golang/final-project/pkg/my-project/model"
+	"github.com/Aminochka4/Golang/final-project/pkg/my-project/validator"
 	"github.com/gorilla/mux"
 	"strconv"
+	"time"
 
 	//"github.com/gorilla/mux"
 	"log"
@@ -11,8 +14,11 @@ import (
 	//"strconv"
 )
 
+// answerReceiptHeader is the request header carrying the receipt of an anonymous answer.
+const answerReceiptHeader = "X-Answer-Receipt"
+
 func (app *application) getAllAnswersHandler(w http.ResponseWriter, r *http.Request) {
-	answer, err := app.models.Answer.GetAll()
+	answer, err := app.models.Answer.GetAll(app.contextGetUser(r).Id)
 	if err != nil {
 		app.respondWithError(w, http.StatusInternalServerError, "Failed to fetch questionnaires")
 		return
@@ -21,26 +27,14 @@ func (app *application) getAllAnswersHandler(w http.ResponseWriter, r *http.Requ
 }
 
 func (app *application) createAnswerHandler(w http.ResponseWriter, r *http.Request) {
-	tokenString, err := app.extractToken(r)
-	if err != nil {
-		app.respondWithError(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
-
-	// Получить userId из токена
-	userID, err := app.getUserIdFromToken(tokenString)
-	//fmt.Println("createQuestionnaireHandler called")
-	if err != nil {
-		app.respondWithError(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
+	userID := app.contextGetUser(r).Id
 
 	var input struct {
-		QuestionnaireId string `json:"questionnaireId"`
-		Answer          string `json:"answer"`
+		QuestionnaireId int64             `json:"questionnaireId"`
+		Responses       []*model.Response `json:"responses"`
 	}
 
-	err = app.readJSON(w, r, &input)
+	err := app.readJSON(w, r, &input)
 	if err != nil {
 		log.Println(err)
 		app.errorResponse(w, r, http.StatusBadRequest, "Invalid request payload")
@@ -49,13 +43,53 @@ func (app *application) createAnswerHandler(w http.ResponseWriter, r *http.Reque
 
 	answer := &model.Answer{
 		QuestionnaireId: input.QuestionnaireId,
-		Answer:          input.Answer,
+		Responses:       input.Responses,
 		UserId:          userID,
 	}
 
+	v := validator.New()
+
+	// The answer is validated against the questions of the questionnaire it responds to, so
+	// that questionnaire has to exist first. Other users' drafts don't exist as far as the
+	// respondent is concerned.
+	questionnaire, err := app.models.Questionnaires.Get(int(answer.QuestionnaireId))
+	if err != nil && !errors.Is(err, model.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err != nil || !questionnaire.VisibleTo(userID) {
+		v.AddError("questionnaireId", "must reference an existing questionnaire")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if !questionnaire.IsOpen(time.Now()) {
+		app.questionnaireNotOpenResponse(w, r)
+		return
+	}
+
+	questions, err := app.models.Questions.GetAllForQuestionnaire(answer.QuestionnaireId)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if model.ValidateAnswer(v, answer, questions); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	err = app.models.Answer.Insert(answer)
 	if err != nil {
-		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
+		switch {
+		case errors.Is(err, model.ErrQuestionnaireNotOpen):
+			app.questionnaireNotOpenResponse(w, r)
+		case errors.Is(err, model.ErrDuplicateResponse):
+			app.duplicateResponseResponse(w, r)
+		default:
+			app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
+		}
 		return
 	}
 
@@ -79,24 +113,18 @@ func (app *application) getAnswerHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	app.respondWithJson(w, http.StatusOK, answer)
-}
-
-func (app *application) updateAnswerHandler(w http.ResponseWriter, r *http.Request) {
-	tokenString, err := app.extractToken(r)
+	questionnaire, err := app.models.Questionnaires.Get(int(answer.QuestionnaireId))
 	if err != nil {
-		app.respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	// Получить userId из токена
-	userID, err := app.getUserIdFromToken(tokenString)
-	//fmt.Println("createQuestionnaireHandler called")
-	if err != nil {
-		app.respondWithError(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
+	answer.Redact(app.contextGetUser(r).Id, questionnaire.UserId)
+
+	app.respondWithJson(w, http.StatusOK, answer)
+}
 
+func (app *application) updateAnswerHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	param := vars["answerId"]
 
@@ -113,12 +141,15 @@ func (app *application) updateAnswerHandler(w http.ResponseWriter, r *http.Reque
 	}
 
 	var input struct {
-		QuestionnaireId *string `json:"questionnaireId"`
-		Answer          *string `json:"answer"`
+		Responses []*model.Response `json:"responses"`
 	}
 
-	if userID != answer.UserId {
-		app.respondWithError(w, http.StatusInternalServerError, "Cannot update other's answer")
+	if !app.authorizeAnswerChange(w, r, answer, "Cannot update other's answer") {
+		return
+	}
+
+	if !answer.IsEditable(time.Now()) {
+		app.editWindowClosedResponse(w, r)
 		return
 	}
 
@@ -128,36 +159,50 @@ func (app *application) updateAnswerHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	if input.QuestionnaireId != nil {
-		answer.QuestionnaireId = *input.QuestionnaireId
+	// Answers can only be changed while their questionnaire still accepts answers.
+	questionnaire, err := app.models.Questionnaires.Get(int(answer.QuestionnaireId))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
 	}
 
-	if input.Answer != nil {
-		answer.Answer = *input.Answer
+	if !questionnaire.IsOpen(time.Now()) {
+		app.questionnaireNotOpenResponse(w, r)
+		return
 	}
 
-	err = app.models.Answer.Update(answer)
+	// The responses in the request body replace the previous ones and are checked against the
+	// questions of the answered questionnaire, just like a new answer.
+	answer.Responses = input.Responses
+
+	questions, err := app.models.Questions.GetAllForQuestionnaire(answer.QuestionnaireId)
 	if err != nil {
-		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
+		app.serverErrorResponse(w, r, err)
+		return
 	}
 
-	app.respondWithJson(w, http.StatusOK, answer)
-}
+	v := validator.New()
 
-func (app *application) deleteAnswerHandler(w http.ResponseWriter, r *http.Request) {
-	tokenString, err := app.extractToken(r)
-	if err != nil {
-		app.respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+	if model.ValidateAnswer(v, answer, questions); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	// Получить userId из токена
-	userID, err := app.getUserIdFromToken(tokenString)
-	//fmt.Println("createQuestionnaireHandler called")
+	err = app.models.Answer.Update(answer)
 	if err != nil {
-		app.respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		switch {
+		case errors.Is(err, model.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
+
+	app.respondWithJson(w, http.StatusOK, answer)
+}
+
+func (app *application) deleteAnswerHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	param := vars["answerId"]
 
@@ -173,14 +218,26 @@ func (app *application) deleteAnswerHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	if userID != answer.UserId {
-		app.respondWithError(w, http.StatusInternalServerError, "Cannot delete other's answer")
+	if !app.authorizeAnswerChange(w, r, answer, "Cannot delete other's answer") {
+		return
+	}
+
+	if !answer.IsEditable(time.Now()) {
+		app.editWindowClosedResponse(w, r)
 		return
 	}
 
-	err = app.models.Answer.Delete(id)
+	// The receipt and the user are passed on so that the respondent of an anonymous answer may
+	// answer again, see AnswerModel.Delete.
+	err = app.models.Answer.Delete(id, r.Header.Get(answerReceiptHeader), app.contextGetUser(r).Id)
 	if err != nil {
-		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
 	}
 
 	app.respondWithJson(w, http.StatusOK, map[string]string{"result": "success"})
@@ -196,11 +253,45 @@ func (app *application) getAnswerByQuestionnaireHandler(w http.ResponseWriter, r
 		return
 	}
 
+	questionnaire, err := app.models.Questionnaires.Get(questionnaireID)
+	if err != nil || !questionnaire.VisibleTo(app.contextGetUser(r).Id) {
+		app.respondWithError(w, http.StatusNotFound, "404 Not Found")
+		return
+	}
+
 	answers, err := app.models.Answer.GetByQuestionnaire(questionnaireID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	for _, answer := range answers {
+		answer.Redact(app.contextGetUser(r).Id, questionnaire.UserId)
+	}
+
 	app.respondWithJson(w, http.StatusOK, answers)
 }
+
+// authorizeAnswerChange checks that the request comes from the respondent of the answer. The
+// respondent of an anonymous answer proves that with the answer's receipt in the
+// X-Answer-Receipt header, everyone else by being the user the answer belongs to. The route is
+// wrapped in requireReceiptOrPermissions, so a request without a receipt already comes from an
+// activated user with the answer:write permission. If the check fails the matching error
+// response is sent and false is returned.
+func (app *application) authorizeAnswerChange(w http.ResponseWriter, r *http.Request, answer *model.Answer, message string) bool {
+	if receipt := r.Header.Get(answerReceiptHeader); receipt != "" {
+		if !answer.MatchesReceipt(receipt) {
+			app.notPermittedResponse(w, r)
+			return false
+		}
+		return true
+	}
+
+	user := app.contextGetUser(r)
+	if answer.UserId == 0 || user.Id != answer.UserId {
+		app.respondWithError(w, http.StatusForbidden, message)
+		return false
+	}
+
+	return true
+}
@@ -2,14 +2,20 @@ package main
 
 import (
 	"context"
+	"errors"
 	"net/http"
 
 	"github.com/Aminochka4/Golang/final-project/pkg/my-project/model"
+	"github.com/Aminochka4/Golang/final-project/pkg/signedtoken"
 )
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey  = contextKey("user")
+	tokenContextKey = contextKey("token")
+	keyContextKey   = contextKey("apiKey")
+)
 
 func (app *application) contextSetUser(r *http.Request, user *model.User) *http.Request {
 	ctx := context.WithValue(r.Context(), userContextKey, user)
@@ -24,3 +30,53 @@ func (app *application) contextGetUser(r *http.Request) *model.User {
 
 	return user
 }
+
+// contextSetToken stores the authentication token the request was made with, so that it can be
+// revoked or recognised among the user's sessions.
+func (app *application) contextSetToken(r *http.Request, token string) *http.Request {
+	ctx := context.WithValue(r.Context(), tokenContextKey, token)
+	return r.WithContext(ctx)
+}
+
+// contextGetToken returns the authentication token of the request, or "" for anonymous requests.
+func (app *application) contextGetToken(r *http.Request) string {
+	token, _ := r.Context().Value(tokenContextKey).(string)
+	return token
+}
+
+// contextSetAPIKey stores the API key the request was made with, whose permissions limit what
+// the request may do.
+func (app *application) contextSetAPIKey(r *http.Request, key *model.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), keyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+// contextGetAPIKey returns the API key of the request, or nil if it wasn't made with one.
+func (app *application) contextGetAPIKey(r *http.Request) *model.APIKey {
+	key, _ := r.Context().Value(keyContextKey).(*model.APIKey)
+	return key
+}
+
+// loadCurrentUser returns the complete record of the authenticated user. A request made with a
+// signed token only carries the user's id and activation status in its context, so the rest is
+// looked up; otherwise the context already holds it. If the lookup fails, the matching error
+// response is sent and false is returned.
+func (app *application) loadCurrentUser(w http.ResponseWriter, r *http.Request) (*model.User, bool) {
+	user := app.contextGetUser(r)
+	if app.keyring == nil || !signedtoken.LooksSigned(app.contextGetToken(r)) {
+		return user, true
+	}
+
+	user, err := app.models.Users.GetById(int(user.Id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return user, true
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Aminochka4/Golang/final-project/pkg/my-project/model"
+)
+
+// dataExportTTL is how long an export can be downloaded after it was requested.
+const dataExportTTL = 7 * 24 * time.Hour
+
+// deleteExpiredDataExports deletes expired exports every interval, so that archives of personal
+// data aren't kept any longer than they can be downloaded.
+func (app *application) deleteExpiredDataExports(interval time.Duration) {
+	go func() {
+		for {
+			deleted, err := app.models.DataExports.DeleteExpired()
+			if err != nil {
+				app.logger.PrintError(err, map[string]string{"task": "delete expired data exports"})
+			} else if deleted > 0 {
+				app.logger.PrintInfo("expired data exports deleted", map[string]string{
+					"count": strconv.FormatInt(deleted, 10),
+				})
+			}
+
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// createDataExportHandler starts building an archive of everything stored about the current
+// user. The archive is built in the background; the response points to where its status can be
+// polled.
+func (app *application) createDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	userID := app.contextGetUser(r).Id
+
+	export, err := app.models.DataExports.Insert(userID, dataExportTTL)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrExportPending):
+			app.errorResponse(w, r, http.StatusConflict, "another export of yours is still being prepared")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.background(func() {
+		archive, err := app.buildDataExport(userID)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{
+				"export": strconv.FormatInt(export.Id, 10),
+			})
+		}
+
+		// A nil archive marks the export as failed.
+		err = app.models.DataExports.Complete(export.Id, archive)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{
+				"export": strconv.FormatInt(export.Id, 10),
+			})
+		}
+	})
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/users/me/export/%d", export.Id))
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"export": export}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getDataExportHandler returns the status of one of the current user's exports.
+func (app *application) getDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r, "exportId")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	export, err := app.models.DataExports.Get(app.contextGetUser(r).Id, int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"export": export}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// downloadDataExportHandler sends the archive of a ready export as a JSON file.
+func (app *application) downloadDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r, "exportId")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	userID := app.contextGetUser(r).Id
+
+	archive, err := app.models.DataExports.GetArchive(userID, int64(id))
+	if err != nil {
+		if !errors.Is(err, model.ErrRecordNotFound) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// Tell a client that polls too eagerly apart from one asking for an unknown export.
+		export, err := app.models.DataExports.Get(userID, int64(id))
+		switch {
+		case err == nil:
+			app.errorResponse(w, r, http.StatusConflict, fmt.Sprintf("the export is %s", export.Status))
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="export-%d.json"`, id))
+	w.WriteHeader(http.StatusOK)
+	w.Write(archive)
+}
+
+// buildDataExport collects everything stored about a user into a JSON archive: their profile,
+// questionnaires, answers, sessions, roles, permissions and API keys.
+func (app *application) buildDataExport(userID int64) ([]byte, error) {
+	user, err := app.models.Users.GetById(int(userID))
+	if err != nil {
+		return nil, err
+	}
+
+	questionnaires, err := app.models.Questionnaires.GetAllForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	answers, err := app.models.Answer.GetAllForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := app.models.Tokens.GetSessions(userID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := app.models.Permissions.GetRolesForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	apiKeys, err := app.models.APIKeys.GetAllForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	archive := envelope{
+		"exportedAt":     time.Now(),
+		"profile":        user,
+		"questionnaires": questionnaires,
+		"answers":        answers,
+		"sessions":       sessions,
+		"roles":          roles,
+		"permissions":    permissions,
+		"apiKeys":        apiKeys,
+	}
+
+	return json.MarshalIndent(archive, "", "\t")
+}
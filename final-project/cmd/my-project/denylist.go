@@ -0,0 +1,72 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Aminochka4/Golang/final-project/pkg/jsonlog"
+	"github.com/Aminochka4/Golang/final-project/pkg/my-project/model"
+)
+
+// denylist is an in-memory copy of the revoked sessions, consulted when verifying signed access
+// tokens so that requests don't have to touch the database. It is reloaded periodically, so a
+// revocation made by another server process takes up to one interval to apply here.
+type denylist struct {
+	mu       sync.RWMutex
+	sessions map[int64]bool
+	tokens   model.TokenModel
+	logger   *jsonlog.Logger
+}
+
+func newDenylist(tokens model.TokenModel, interval time.Duration, logger *jsonlog.Logger) (*denylist, error) {
+	d := &denylist{tokens: tokens, logger: logger}
+
+	if err := d.reload(); err != nil {
+		return nil, err
+	}
+
+	go func() {
+		for {
+			time.Sleep(interval)
+
+			if err := d.reload(); err != nil {
+				d.logger.PrintError(err, map[string]string{"task": "reload token denylist"})
+			}
+		}
+	}()
+
+	return d, nil
+}
+
+func (d *denylist) reload() error {
+	sessions, err := d.tokens.Denylist()
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	d.sessions = sessions
+	d.mu.Unlock()
+
+	return nil
+}
+
+// denied reports whether a session has been revoked.
+func (d *denylist) denied(session int64) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.sessions[session]
+}
+
+// revoked makes sessions revoked by the current request apply to signed tokens right away,
+// rather than after the next periodic reload of the denylist.
+func (app *application) revoked() {
+	if app.denylist == nil {
+		return
+	}
+
+	if err := app.denylist.reload(); err != nil {
+		app.logger.PrintError(err, map[string]string{"task": "reload token denylist"})
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Aminochka4/Golang/final-project/pkg/my-project/model"
+	"github.com/Aminochka4/Golang/final-project/pkg/my-project/validator"
+)
+
+// requestEmailChangeHandler starts changing the current user's email address. The new address
+// is sent a token to confirm it with, and the old one a notice with a token that reverts it.
+func (app *application) requestEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.loadCurrentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Email           string `json:"email"`
+		CurrentPassword string `json:"currentPassword"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	email := model.NormalizeEmail(input.Email)
+
+	v := validator.New()
+
+	model.ValidateEmail(v, email)
+	v.Check(email != user.Email, "email", "must be different from your current email address")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	match := false
+	if input.CurrentPassword != "" {
+		match, err = user.Password.Matches(input.CurrentPassword)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if !match {
+		v.AddError("currentPassword", "must be your current password to change the email address")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	_, err = app.models.Users.GetByEmail(email)
+	switch {
+	case err == nil:
+		v.AddError("email", "a user with this email address already exists")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	case !errors.Is(err, model.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	revert, err := app.models.Tokens.New(user.Id, 7*24*time.Hour, model.ScopeEmailRevert)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	_, err = app.models.EmailChanges.Request(user.Id, user.Email, email, revert)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	confirm, err := app.models.Tokens.New(user.Id, 24*time.Hour, model.ScopeEmailChange)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.sendEmail(email, "email_change_confirm.tmpl", map[string]interface{}{
+		"emailChangeToken": confirm.Plaintext,
+		"name":             user.Name,
+	})
+
+	app.sendEmail(user.Email, "email_change_notice.tmpl", map[string]interface{}{
+		"emailRevertToken": revert.Plaintext,
+		"newEmail":         email,
+		"name":             user.Name,
+	})
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"message": "a confirmation token has been sent to the new email address"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// confirmEmailChangeHandler applies a pending email change with the token sent to the new
+// address.
+func (app *application) confirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	user, _, ok := app.userForEmailToken(w, r, model.ScopeEmailChange)
+	if !ok {
+		return
+	}
+
+	change, err := app.models.EmailChanges.Confirm(user.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.failedValidationResponse(w, r, map[string]string{"token": "invalid or expired email change token"})
+		case errors.Is(err, model.ErrDuplicateEmail):
+			app.failedValidationResponse(w, r, map[string]string{"email": "a user with this email address already exists"})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user.Email = change.NewEmail
+	user.Version++
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revertEmailChangeHandler undoes an email change, and any made after it, with the token sent
+// to the address it replaced. As the change may not have been made by the owner of the account,
+// every session is logged out as well.
+func (app *application) revertEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	user, token, ok := app.userForEmailToken(w, r, model.ScopeEmailRevert)
+	if !ok {
+		return
+	}
+
+	change, err := app.models.EmailChanges.Revert(user.Id, token)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.failedValidationResponse(w, r, map[string]string{"token": "invalid or expired email revert token"})
+		case errors.Is(err, model.ErrDuplicateEmail):
+			app.failedValidationResponse(w, r, map[string]string{"email": "a user with this email address already exists"})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(model.ScopeAuthentication, user.Id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.revoked()
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"emailChange": change}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// userForEmailToken reads a token of the given scope from the request body and returns it with
+// the user it was issued to. If that fails, the matching error response is sent and false is
+// returned.
+func (app *application) userForEmailToken(w http.ResponseWriter, r *http.Request, scope string) (*model.User, string, bool) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return nil, "", false
+	}
+
+	v := validator.New()
+
+	if model.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, "", false
+	}
+
+	user, err := app.models.Users.GetForToken(scope, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, "", false
+	}
+
+	return user, input.TokenPlaintext, true
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 // logError method is a generic helper for logging an error message in *application, as well
@@ -92,6 +95,20 @@ func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
+// invalidAPIKeyResponse sends a JSON-formatted error with a 401 Unauthorized status code to the
+// client when the API key it sent is unknown, revoked or expired.
+func (app *application) invalidAPIKeyResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid or expired API key"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// apiKeyNotAllowedResponse sends a JSON-formatted error with a 403 Forbidden status code to the
+// client when it calls an endpoint that can only be used by a logged in user with an API key.
+func (app *application) apiKeyNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this resource can't be accessed with an API key"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
 // authenticationRequiredResponse sends a JSON-formatted error with a 401 Unauthorized status code
 // to the client.
 func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
@@ -106,7 +123,37 @@ func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.R
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
 
+// questionnaireNotOpenResponse sends a JSON-formatted error with a 409 Conflict status code to the
+// client when it answers a questionnaire that doesn't accept answers.
+func (app *application) questionnaireNotOpenResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the questionnaire is not open for answers"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+// duplicateResponseResponse sends a JSON-formatted error with a 409 Conflict status code to the
+// client when it answers a single response questionnaire a second time.
+func (app *application) duplicateResponseResponse(w http.ResponseWriter, r *http.Request) {
+	message := "you have already answered this questionnaire"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+// editWindowClosedResponse sends a JSON-formatted error with a 403 Forbidden status code to the
+// client when it changes an answer after the questionnaire's edit window has passed.
+func (app *application) editWindowClosedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the answer can no longer be changed because its edit window has closed"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
 func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your user account doesn't have the necessary permissions to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+// tooManyLoginAttemptsResponse sends a JSON-formatted error with a 429 Too Many Requests status
+// code and a Retry-After header to the client when it has to wait before trying to log in again.
+func (app *application) tooManyLoginAttemptsResponse(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+
+	message := "too many failed login attempts, please try again later"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Aminochka4/Golang/final-project/pkg/my-project/model"
+	"github.com/Aminochka4/Golang/final-project/pkg/my-project/validator"
+)
+
+// exportFlushEvery is the number of rows written between flushes of the response, so that the
+// client starts receiving data before the whole export has been produced.
+const exportFlushEvery = 100
+
+// exportAnswersHandler streams the answers to a version of a questionnaire, the current one
+// unless ?version is given, to its owner. They are written either as CSV with one column per
+// question of that version and one row per answer, or as newline-delimited JSON. Rows are
+// written as they are read from the database instead of being collected first.
+func (app *application) exportAnswersHandler(w http.ResponseWriter, r *http.Request) {
+	questionnaire, ok := app.ownedQuestionnaire(w, r)
+	if !ok {
+		return
+	}
+
+	v := validator.New()
+
+	format := app.readStrings(r.URL.Query(), "format", "csv")
+
+	if v.Check(validator.In(format, "csv", "ndjson"), "format", "must be csv or ndjson"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	version, ok := app.readVersion(w, r, questionnaire)
+	if !ok {
+		return
+	}
+
+	// A large export can take longer than the server's write timeout, so lift the deadline
+	// for this response only. Not every ResponseWriter supports this, which is fine.
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	var write func(*model.Answer) error
+	var flush func() error
+	var err error
+
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		write, err = csvAnswerWriter(cw, version.Questions)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		flush = func() error {
+			cw.Flush()
+			if err := cw.Error(); err != nil {
+				return err
+			}
+			return rc.Flush()
+		}
+
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	case "ndjson":
+		enc := json.NewEncoder(w)
+		write = func(answer *model.Answer) error {
+			return enc.Encode(answer)
+		}
+		flush = rc.Flush
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="questionnaire-%d-answers.%s"`, questionnaire.Id, format))
+	w.WriteHeader(http.StatusOK)
+
+	rows := 0
+	err = app.models.Answer.ForEachByQuestionnaire(r.Context(), questionnaire.Id, version.Version, func(answer *model.Answer) error {
+		if err := write(answer); err != nil {
+			return err
+		}
+
+		rows++
+		if rows%exportFlushEvery == 0 {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+
+	// The status line has already been sent, so all that can be done about an error now is
+	// to log it; the client sees a truncated download.
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		app.logError(r, err)
+	}
+}
+
+// csvAnswerWriter writes the header row to cw and returns a function that writes one row per
+// answer, with the responses placed in the column of the question they respond to.
+func csvAnswerWriter(cw *csv.Writer, questions []*model.Question) (func(*model.Answer) error, error) {
+	header := []string{"answerId", "userId", "createdAt", "updatedAt"}
+	fixed := len(header)
+
+	columns := make(map[int64]int, len(questions))
+	optionTexts := make(map[int64]string)
+	for i, question := range questions {
+		header = append(header, fmt.Sprintf("Q%d. %s", question.Position, question.Text))
+		columns[question.Id] = fixed + i
+
+		for _, option := range question.Options {
+			optionTexts[option.Id] = option.Text
+		}
+	}
+
+	if err := cw.Write(header); err != nil {
+		return nil, err
+	}
+
+	return func(answer *model.Answer) error {
+		record := make([]string, len(header))
+		record[0] = strconv.FormatInt(answer.Id, 10)
+		if answer.UserId != 0 {
+			record[1] = strconv.FormatInt(answer.UserId, 10)
+		}
+		record[2] = answer.CreatedAt
+		record[3] = answer.UpdatedAt
+
+		for _, response := range answer.Responses {
+			column, ok := columns[response.QuestionId]
+			if !ok {
+				continue
+			}
+
+			switch {
+			case len(response.OptionIds) > 0:
+				selected := make([]string, 0, len(response.OptionIds))
+				for _, id := range response.OptionIds {
+					selected = append(selected, optionTexts[id])
+				}
+				record[column] = strings.Join(selected, "; ")
+			case response.Number != nil:
+				record[column] = strconv.Itoa(*response.Number)
+			case response.Text != nil:
+				record[column] = *response.Text
+			}
+		}
+
+		return cw.Write(record)
+	}, nil
+}
@@ -17,15 +17,39 @@ import (
 // Define an envelope type.
 type envelope map[string]interface{}
 
-// readIDParam reads interpolated "id" from request URL and returns it and nil. If there is an error
-// it returns and 0 and an error.
-func (app *application) readIDParam(r *http.Request) (int, error) {
+// nullable is a field of a partial update that can be left out to keep its current value, or
+// set to null to clear it. Set reports whether the field was in the request body at all.
+type nullable[T any] struct {
+	Set   bool
+	Value *T
+}
+
+func (n *nullable[T]) UnmarshalJSON(data []byte) error {
+	n.Set = true
+
+	if string(data) == "null" {
+		n.Value = nil
+		return nil
+	}
+
+	var value T
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	n.Value = &value
+	return nil
+}
+
+// readIDParam reads the interpolated id called name from request URL and returns it and nil. If
+// there is an error it returns and 0 and an error.
+func (app *application) readIDParam(r *http.Request, name string) (int, error) {
 	vars := mux.Vars(r)
-	param := vars["id"]
+	param := vars[name]
 
 	id, err := strconv.Atoi(param)
 	if err != nil || id < 1 {
-		return 0, errors.New("invalid id parameter")
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
 
 	return id, nil
@@ -177,6 +201,25 @@ func (app *application) readStrings(qs url.Values, key string, defaultValue stri
 	return s
 }
 
+// readBool reads a "true" or "false" value from the URL query string. If no matching key is
+// found it returns the provided default value; any other value is recorded as an error in the
+// provided Validator instance.
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be true or false")
+		return defaultValue
+	}
+
+	return b
+}
+
 // readInt is a helper method on application type that reads a string value from the URL query
 // string and converts it to an integer before returning. If no matching key is found then it
 // returns the provided default value. If the value couldn't be converted to an integer, then we
@@ -201,3 +244,35 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	// Otherwise, return the converted integer value.
 	return i
 }
+
+// background runs fn in a goroutine that the graceful shutdown in serve() waits for. A panic in
+// fn is logged instead of bringing the server down.
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.PrintError(fmt.Errorf("%s", err), nil)
+			}
+		}()
+
+		fn()
+	}()
+}
+
+// sendEmail renders and sends an email in the background. Delivery failures are only logged, as
+// the response has usually been sent by the time they happen.
+func (app *application) sendEmail(recipient, templateFile string, data map[string]interface{}) {
+	app.background(func() {
+		err := app.mailer.Send(recipient, templateFile, data)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{
+				"recipient": recipient,
+				"template":  templateFile,
+			})
+		}
+	})
+}
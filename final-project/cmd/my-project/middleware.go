@@ -4,9 +4,11 @@ import (
 	"errors"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/Aminochka4/Golang/final-project/pkg/my-project/model"
 	"github.com/Aminochka4/Golang/final-project/pkg/my-project/validator"
+	"github.com/Aminochka4/Golang/final-project/pkg/signedtoken"
 )
 
 func (app *application) authenticate(next http.Handler) http.Handler {
@@ -14,6 +16,33 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// Add the "Vary: Authorization" header to the response. This indicates to any caches
 		// that the response may vary based on the value of the Authorization header in the request.
 		w.Header().Set("Vary", "Authorization")
+		w.Header().Add("Vary", "X-API-Key")
+
+		// Service accounts and integrations send an API key in its own header instead. The
+		// request acts as the key's user, limited to the key's permissions by requirePermissions.
+		if apiKey := r.Header.Get("X-API-Key"); apiKey != "" {
+			if !model.IsAPIKey(apiKey) {
+				app.invalidAPIKeyResponse(w, r)
+				return
+			}
+
+			key, user, err := app.models.APIKeys.GetForKey(apiKey)
+			if err != nil {
+				switch {
+				case errors.Is(err, model.ErrRecordNotFound):
+					app.invalidAPIKeyResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+
+			r = app.contextSetUser(r, user)
+			r = app.contextSetAPIKey(r, key)
+
+			next.ServeHTTP(w, r)
+			return
+		}
 
 		// Retrieve the value of the Authorization header from teh request. This will return the
 		// empty string "" if there is no such header found.
@@ -41,6 +70,25 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// Extract the actual authentication toekn from the header parts
 		token := headerParts[1]
 
+		// Signed tokens carry everything needed to authenticate the request, so they are
+		// verified without a database query. Handlers that need more of the user than its id
+		// and activation status load it with loadCurrentUser.
+		if app.keyring != nil && signedtoken.LooksSigned(token) {
+			claims, err := app.keyring.Verify(token, time.Now())
+			if err != nil || app.denylist.denied(claims.Session) {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+
+			app.sessionUsed(claims.Session)
+
+			r = app.contextSetUser(r, &model.User{Id: claims.Subject, Activated: claims.Activated})
+			r = app.contextSetToken(r, token)
+
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		// Validate the token to make sure it is in a sensible format.
 		v := validator.New()
 
@@ -66,6 +114,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 
 		// Call the contextSetUser healer to add the user information to the request context.
 		r = app.contextSetUser(r, user)
+		r = app.contextSetToken(r, token)
 
 		// Call next handler in chain
 		next.ServeHTTP(w, r)
@@ -73,7 +122,22 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 }
 
 // requireAuthenticatedUser checks that the user is not anonymous (i.e., they are authenticated).
+// Requests made with an API key are refused, because the key's permissions can only be checked
+// by requirePermissions.
 func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if app.contextGetAPIKey(r) != nil {
+			app.apiKeyNotAllowedResponse(w, r)
+			return
+		}
+
+		app.requireUser(next).ServeHTTP(w, r)
+	}
+}
+
+// requireUser checks that the user is not anonymous, whether they authenticated with a token or
+// an API key.
+func (app *application) requireUser(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Use the contextGetUser helper to retrieve the user information from the request context.
 		user := app.contextGetUser(r)
@@ -91,8 +155,14 @@ func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.Han
 
 // requiredActivatedUser checks that the user is both authenticated and activated.
 func (app *application) requireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
-	// Rather than returning this http.HandlerFunc we assign it to the variable fn.
-	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	// Wrap with the requireAuthenticatedUser middleware before returning it.
+	return app.requireAuthenticatedUser(app.activated(next))
+}
+
+// activated checks that the user is activated, and is wrapped by a middleware that makes sure
+// they are authenticated.
+func (app *application) activated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
 
 		// Check that a user is activated
@@ -102,27 +172,23 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 		}
 
 		next.ServeHTTP(w, r)
-	})
-
-	// Wrap fn with the requireAuthenticatedUser middleware before returning it.
-	return app.requireAuthenticatedUser(fn)
+	}
 }
 
+// requirePermissions checks that the user is authenticated, activated and has the permission.
+// Unlike the other middlewares it also accepts requests made with an API key that carries the
+// permission.
 func (app *application) requirePermissions(code string, next http.HandlerFunc) http.HandlerFunc {
 	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Retrieve the user from the request context.
-		user := app.contextGetUser(r)
-
-		// Get the slice of permission for the user
-		permissions, err := app.models.Permissions.GetAllForUser(user.Id)
+		permitted, err := app.hasPermission(r, code)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
 		}
 
-		// Check if the slice includes the required permission. If it doesn't, then return a 403
-		// Forbidden response.
-		if !permissions.Include(code) {
+		// If the user doesn't have the required permission, then return a 403 Forbidden
+		// response.
+		if !permitted {
 			app.notPermittedResponse(w, r)
 			return
 		}
@@ -131,6 +197,45 @@ func (app *application) requirePermissions(code string, next http.HandlerFunc) h
 		next.ServeHTTP(w, r)
 	})
 
-	// Wrap this with the requireActivatedUser middleware before returning
-	return app.requireActivatedUser(fn)
+	// Wrap this with the middlewares that check the user is authenticated, with a token or an
+	// API key, and activated before returning.
+	return app.requireUser(app.activated(fn))
+}
+
+// requireReceiptOrPermissions is requirePermissions for the routes that change an answer.
+// Anonymous answers aren't linked to their respondent, so the receipt is the proof of ownership
+// for them: a request carrying an answer receipt is let through as it is, and the handler checks
+// the receipt against the answer.
+func (app *application) requireReceiptOrPermissions(code string, next http.HandlerFunc) http.HandlerFunc {
+	permitted := app.requirePermissions(code, next)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(answerReceiptHeader) != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		permitted.ServeHTTP(w, r)
+	}
+}
+
+// hasPermission reports whether the authenticated user of the request has a permission, either
+// directly or through one of their roles.
+func (app *application) hasPermission(r *http.Request, code string) (bool, error) {
+	// Retrieve the user from the request context.
+	user := app.contextGetUser(r)
+
+	// Get the slice of permission for the user
+	permissions, err := app.models.Permissions.GetAllForUser(user.Id)
+	if err != nil {
+		return false, err
+	}
+
+	// A request made with an API key is also limited to the permissions of the key. The user
+	// still has to hold them, in case they were revoked after the key was created.
+	if key := app.contextGetAPIKey(r); key != nil && !key.Permissions.Include(code) {
+		return false, nil
+	}
+
+	return permissions.Include(code), nil
 }
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Aminochka4/Golang/final-project/pkg/my-project/model"
+	"github.com/Aminochka4/Golang/final-project/pkg/my-project/validator"
+	"github.com/Aminochka4/Golang/final-project/pkg/oidc"
+	"github.com/gorilla/mux"
+)
+
+// listProvidersHandler returns the names of the OpenID Connect providers users can sign in with.
+func (app *application) listProvidersHandler(w http.ResponseWriter, r *http.Request) {
+	names := []string{}
+	for name := range app.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"providers": names}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// oidcLoginHandler sends the client to the login page of a provider. The provider redirects back
+// to oidcCallbackHandler, which has to be the redirectUrl configured for it.
+func (app *application) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := app.providers[mux.Vars(r)["provider"]]
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	login, err := oidc.NewLogin()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Identities.InsertLogin(login.State, &model.PendingLogin{
+		Provider:     provider.Name,
+		Nonce:        login.Nonce,
+		CodeVerifier: login.CodeVerifier,
+	}, 10*time.Minute)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	url, err := provider.AuthCodeURL(r.Context(), login)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// oidcCallbackHandler completes a login at a provider and starts a session for the user, which
+// still asks for a second factor if the user enabled one. The user is found by the account they
+// used at the provider; the first time, an existing user with the same verified email address is
+// linked to it, or else a new, activated user is created.
+func (app *application) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := app.providers[mux.Vars(r)["provider"]]
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	qs := r.URL.Query()
+
+	if qs.Get("error") != "" {
+		app.errorResponse(w, r, http.StatusUnauthorized, fmt.Sprintf("the provider refused the login: %s", qs.Get("error")))
+		return
+	}
+
+	pending, err := app.models.Identities.ConsumeLogin(qs.Get("state"))
+	if err != nil || pending.Provider != provider.Name {
+		switch {
+		case err == nil, errors.Is(err, model.ErrRecordNotFound):
+			app.badRequestResponse(w, r, errors.New("unknown or expired login, please start again"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	claims, err := provider.Exchange(r.Context(), &oidc.Login{
+		State:        qs.Get("state"),
+		Nonce:        pending.Nonce,
+		CodeVerifier: pending.CodeVerifier,
+	}, qs.Get("code"))
+	if err != nil {
+		switch {
+		case errors.Is(err, oidc.ErrRejected):
+			app.logger.PrintInfo("oidc login rejected", map[string]string{
+				"provider": provider.Name,
+				"error":    err.Error(),
+			})
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.models.Identities.GetUser(provider.Name, claims.Subject)
+	if errors.Is(err, model.ErrRecordNotFound) {
+		user, err = app.linkIdentity(provider.Name, claims)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, errUnverifiedEmail):
+			app.errorResponse(w, r, http.StatusForbidden, "the provider hasn't verified your email address")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.startSession(w, r, user)
+}
+
+var errUnverifiedEmail = errors.New("email address not verified by the provider")
+
+// linkIdentity links an account at a provider to the user with the same email address, creating
+// the user if there is none. The provider must have verified the address, or anyone could claim
+// an account by registering its address at a provider.
+//
+// Only activated users are linked to. An unactivated user with the address was registered by
+// someone who never proved they own it, possibly to take the account over once its real owner
+// signs in, so it is deleted, with its sessions, and replaced by a new user.
+func (app *application) linkIdentity(provider string, claims *oidc.Claims) (*model.User, error) {
+	email := model.NormalizeEmail(claims.Email)
+	if email == "" || !claims.EmailVerified {
+		return nil, errUnverifiedEmail
+	}
+
+	user, err := app.models.Users.GetByEmail(email)
+	if err == nil && !user.Activated {
+		err = app.models.Tokens.DeleteAllForUser(model.ScopeAuthentication, user.Id)
+		if err != nil {
+			return nil, err
+		}
+
+		app.revoked()
+
+		err = app.models.Users.Delete(int(user.Id))
+		if err != nil {
+			return nil, err
+		}
+
+		app.logger.PrintInfo("unactivated user replaced by oidc login", map[string]string{
+			"provider": provider,
+			"userId":   strconv.FormatInt(user.Id, 10),
+		})
+
+		err = model.ErrRecordNotFound
+	}
+
+	switch {
+	case err == nil:
+	case errors.Is(err, model.ErrRecordNotFound):
+		user, err = app.createOIDCUser(email, claims)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	err = app.models.Identities.Link(user.Id, provider, claims.Subject, email)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+var usernameUnsafe = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// createOIDCUser creates an activated user for someone signing in with a provider for the first
+// time. The username is derived from the one at the provider or from the email address, with a
+// random suffix if it is taken, and the password is random: it can be set with a password reset.
+func (app *application) createOIDCUser(email string, claims *oidc.Claims) (*model.User, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(email, "@")
+	}
+	base = usernameUnsafe.ReplaceAllString(base, "")
+	if len(base) > 12 {
+		base = base[:12]
+	}
+	for len(base) < 3 {
+		base += "_"
+	}
+
+	user := &model.User{
+		Name:      claims.GivenName,
+		Surname:   claims.FamilyName,
+		Email:     email,
+		Activated: true,
+	}
+	if user.Name == "" {
+		user.Name = claims.Name
+	}
+	if user.Name == "" {
+		user.Name = base
+	}
+
+	password := make([]byte, 24)
+	if _, err := rand.Read(password); err != nil {
+		return nil, err
+	}
+	if err := user.Password.Set(base64.RawURLEncoding.EncodeToString(password)); err != nil {
+		return nil, err
+	}
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		user.Username = base
+		if attempt > 0 {
+			suffix, err := rand.Int(rand.Reader, big.NewInt(1000))
+			if err != nil {
+				return nil, err
+			}
+			user.Username = fmt.Sprintf("%s-%03d", base, suffix)
+		}
+
+		v := validator.New()
+		if model.ValidateUser(v, user); !v.Valid() {
+			return nil, fmt.Errorf("invalid user from provider: %v", v.Errors)
+		}
+
+		err = app.models.Users.Insert(user)
+		if errors.Is(err, model.ErrDuplicateUsername) && attempt < 5 {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		break
+	}
+
+	return user, nil
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Aminochka4/Golang/final-project/pkg/my-project/model"
+	"github.com/Aminochka4/Golang/final-project/pkg/my-project/validator"
+)
+
+// questionInput is the JSON representation of a question accepted when creating questions,
+// either one at a time or nested in a questionnaire.
+type questionInput struct {
+	Position int           `json:"position"`
+	Type     string        `json:"type"`
+	Text     string        `json:"text"`
+	Required bool          `json:"required"`
+	ScaleMin *int          `json:"scaleMin"`
+	ScaleMax *int          `json:"scaleMax"`
+	Options  []optionInput `json:"options"`
+	Logic    *model.Logic  `json:"logic"`
+}
+
+type optionInput struct {
+	Id   int64  `json:"id"`
+	Text string `json:"text"`
+}
+
+func (in questionInput) question() *model.Question {
+	return &model.Question{
+		Position: in.Position,
+		Type:     in.Type,
+		Text:     in.Text,
+		Required: in.Required,
+		ScaleMin: in.ScaleMin,
+		ScaleMax: in.ScaleMax,
+		Options:  options(in.Options),
+		Logic:    logic(in.Logic),
+	}
+}
+
+func questions(inputs []questionInput) []*model.Question {
+	questions := make([]*model.Question, 0, len(inputs))
+	for _, in := range inputs {
+		questions = append(questions, in.question())
+	}
+	return questions
+}
+
+func options(inputs []optionInput) []*model.Option {
+	var options []*model.Option
+	for _, in := range inputs {
+		options = append(options, &model.Option{Id: in.Id, Text: in.Text})
+	}
+	return options
+}
+
+// logic drops empty logic, so that sending {} removes the rules of a question.
+func logic(in *model.Logic) *model.Logic {
+	if in.IsEmpty() {
+		return nil
+	}
+	return in
+}
+
+func (app *application) listQuestionsHandler(w http.ResponseWriter, r *http.Request) {
+	questionnaire, ok := app.visibleQuestionnaire(w, r)
+	if !ok {
+		return
+	}
+
+	questions, err := app.models.Questions.GetAllForQuestionnaire(questionnaire.Id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"questions": questions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createQuestionHandler(w http.ResponseWriter, r *http.Request) {
+	questionnaire, ok := app.ownedQuestionnaire(w, r)
+	if !ok {
+		return
+	}
+
+	var input questionInput
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	question := input.question()
+	question.QuestionnaireId = questionnaire.Id
+
+	v := validator.New()
+
+	if model.ValidateQuestion(v, question); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Questions.Insert(question)
+	if err != nil {
+		var logicErr *model.LogicError
+		switch {
+		case errors.As(err, &logicErr):
+			app.failedValidationResponse(w, r, logicErr.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"question": question}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getQuestionHandler(w http.ResponseWriter, r *http.Request) {
+	questionnaire, ok := app.visibleQuestionnaire(w, r)
+	if !ok {
+		return
+	}
+
+	questionID, err := app.readIDParam(r, "questionId")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	question, err := app.models.Questions.Get(questionnaire.Id, int64(questionID))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"question": question}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateQuestionHandler(w http.ResponseWriter, r *http.Request) {
+	questionnaire, ok := app.ownedQuestionnaire(w, r)
+	if !ok {
+		return
+	}
+
+	questionID, err := app.readIDParam(r, "questionId")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	question, err := app.models.Questions.Get(questionnaire.Id, int64(questionID))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Use pointers so that only the fields present in the request body are changed. Options,
+	// when given, replace the whole list; options that keep their id keep their identity.
+	var input struct {
+		Position *int           `json:"position"`
+		Type     *string        `json:"type"`
+		Text     *string        `json:"text"`
+		Required *bool          `json:"required"`
+		ScaleMin *int           `json:"scaleMin"`
+		ScaleMax *int           `json:"scaleMax"`
+		Options  *[]optionInput `json:"options"`
+		Logic    *model.Logic   `json:"logic"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Position != nil {
+		question.Position = *input.Position
+	}
+	if input.Type != nil {
+		question.Type = *input.Type
+	}
+	if input.Text != nil {
+		question.Text = *input.Text
+	}
+	if input.Required != nil {
+		question.Required = *input.Required
+	}
+	if input.ScaleMin != nil {
+		question.ScaleMin = input.ScaleMin
+	}
+	if input.ScaleMax != nil {
+		question.ScaleMax = input.ScaleMax
+	}
+	if input.Options != nil {
+		question.Options = options(*input.Options)
+	}
+	if input.Logic != nil {
+		question.Logic = logic(input.Logic)
+	}
+
+	// Switching away from a scale or choice question drops the settings that no longer apply.
+	if question.Type != model.QuestionScale {
+		question.ScaleMin, question.ScaleMax = nil, nil
+	}
+	if !question.IsChoice() {
+		question.Options = nil
+	}
+
+	v := validator.New()
+
+	if model.ValidateQuestion(v, question); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Questions.Update(question)
+	if err != nil {
+		var logicErr *model.LogicError
+		switch {
+		case errors.As(err, &logicErr):
+			app.failedValidationResponse(w, r, logicErr.Errors)
+		case errors.Is(err, model.ErrRecordNotFound):
+			v.AddError("options", "must only reference options of this question")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"question": question}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteQuestionHandler(w http.ResponseWriter, r *http.Request) {
+	questionnaire, ok := app.ownedQuestionnaire(w, r)
+	if !ok {
+		return
+	}
+
+	questionID, err := app.readIDParam(r, "questionId")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Questions.Delete(questionnaire.Id, int64(questionID))
+	if err != nil {
+		var logicErr *model.LogicError
+		switch {
+		case errors.As(err, &logicErr):
+			app.failedValidationResponse(w, r, logicErr.Errors)
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "question successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
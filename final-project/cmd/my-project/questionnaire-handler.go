@@ -8,7 +8,7 @@ import (
 	"log"
 	"net/http"
 	"strconv"
-	"strings"
+	"time"
 )
 
 func (app *application) respondWithError(w http.ResponseWriter, code int, message string) {
@@ -27,6 +27,14 @@ func (app *application) getAllQuestionnairesHandler(w http.ResponseWriter, r *ht
 	page := app.readInt(r.URL.Query(), "page", 1, v)
 	pageSize := app.readInt(r.URL.Query(), "page_size", 10, v)
 
+	// Templates are listed in a gallery of their own, requested with template=true.
+	template := app.readBool(r.URL.Query(), "template", false, v)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	// Создаем экземпляр структуры Filters и устанавливаем параметры сортировки и пагинации
 	filters := model.Filters{
 		Sort:         sort,
@@ -36,7 +44,7 @@ func (app *application) getAllQuestionnairesHandler(w http.ResponseWriter, r *ht
 	}
 
 	// Вызываем функцию GetAll с переданными значениями topic и filters
-	questionnaires, err := app.models.Questionnaires.GetAll(topic, filters)
+	questionnaires, err := app.models.Questionnaires.GetAll(topic, template, app.contextGetUser(r).Id, filters)
 	if err != nil {
 		app.respondWithError(w, http.StatusInternalServerError, "Failed to fetch questionnaires")
 		return
@@ -45,61 +53,51 @@ func (app *application) getAllQuestionnairesHandler(w http.ResponseWriter, r *ht
 	app.respondWithJson(w, http.StatusOK, questionnaires)
 }
 
-func (app *application) extractToken(r *http.Request) (string, error) {
-	// Извлечь токен из заголовка Authorization
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		return "", errors.New("authorization header is missing")
-	}
-	parts := strings.Split(authHeader, " ")
-	if len(parts) != 2 || parts[0] != "Bearer" {
-		return "", errors.New("invalid authorization header format")
-	}
-
-	// Вернуть токен
-	return parts[1], nil
-}
-
-func (app *application) getUserIdFromToken(tokenString string) (int64, error) {
-	// Распарсить токен и получить userId
-	token, err := app.models.Tokens.Parse(tokenString)
-	if err != nil {
-		return 0, err
-	}
-	return token.UserID, nil
-}
-
 func (app *application) createQuestionnaireHandler(w http.ResponseWriter, r *http.Request) {
-	tokenString, err := app.extractToken(r)
-	if err != nil {
-		app.respondWithError(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
-
-	// Получить userId из токена
-	userID, err := app.getUserIdFromToken(tokenString)
-	//fmt.Println("createQuestionnaireHandler called")
-	if err != nil {
-		app.respondWithError(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
+	userID := app.contextGetUser(r).Id
 
 	var input struct {
-		Topic     string `json:"topic"`
-		Questions string `json:"questions"`
+		Topic             string          `json:"topic"`
+		OpensAt           *time.Time      `json:"opensAt"`
+		ClosesAt          *time.Time      `json:"closesAt"`
+		Anonymous         bool            `json:"anonymous"`
+		IsTemplate        bool            `json:"isTemplate"`
+		ResponsePolicy    string          `json:"responsePolicy"`
+		EditWindowMinutes *int            `json:"editWindowMinutes"`
+		Questions         []questionInput `json:"questions"`
 	}
 
-	err = app.readJSON(w, r, &input)
+	err := app.readJSON(w, r, &input)
 	if err != nil {
 		log.Println(err)
 		app.errorResponse(w, r, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
 
+	// Each user can answer a questionnaire once unless the owner asks for something else.
+	if input.ResponsePolicy == "" {
+		input.ResponsePolicy = model.ResponsePolicySingle
+	}
+
+	// New questionnaires start out as drafts and have to be published before they accept answers.
 	questionnaire := &model.Questionnaire{
-		Topic:     input.Topic,
-		Questions: input.Questions,
-		UserId:    userID,
+		Topic:             input.Topic,
+		Status:            model.StatusDraft,
+		OpensAt:           input.OpensAt,
+		ClosesAt:          input.ClosesAt,
+		Anonymous:         input.Anonymous,
+		IsTemplate:        input.IsTemplate,
+		ResponsePolicy:    input.ResponsePolicy,
+		EditWindowMinutes: input.EditWindowMinutes,
+		Questions:         questions(input.Questions),
+		UserId:            userID,
+	}
+
+	v := validator.New()
+
+	if model.ValidateQuestionnaire(v, questionnaire); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
 	}
 
 	err = app.models.Questionnaires.Insert(questionnaire)
@@ -122,28 +120,22 @@ func (app *application) getQuestionnaireHandler(w http.ResponseWriter, r *http.R
 	}
 
 	questionnaire, err := app.models.Questionnaires.Get(id)
-	if err != nil {
+	if err != nil || !questionnaire.VisibleTo(app.contextGetUser(r).Id) {
 		app.respondWithError(w, http.StatusNotFound, "404 Not Found")
 		return
 	}
 
-	app.respondWithJson(w, http.StatusOK, questionnaire)
-}
-
-func (app *application) updateQuestionnaireHandler(w http.ResponseWriter, r *http.Request) {
-	tokenString, err := app.extractToken(r)
+	questionnaire.Questions, err = app.models.Questions.GetAllForQuestionnaire(questionnaire.Id)
 	if err != nil {
-		app.respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	// Получить userId из токена
-	userID, err := app.getUserIdFromToken(tokenString)
-	//fmt.Println("createQuestionnaireHandler called")
-	if err != nil {
-		app.respondWithError(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
+	app.respondWithJson(w, http.StatusOK, questionnaire)
+}
+
+func (app *application) updateQuestionnaireHandler(w http.ResponseWriter, r *http.Request) {
+	userID := app.contextGetUser(r).Id
 
 	vars := mux.Vars(r)
 	param := vars["questionnaireId"]
@@ -161,8 +153,14 @@ func (app *application) updateQuestionnaireHandler(w http.ResponseWriter, r *htt
 	}
 
 	var input struct {
-		Topic     *string `json:"topic"`
-		Questions *string `json:"questions"`
+		Topic             *string             `json:"topic"`
+		OpensAt           nullable[time.Time] `json:"opensAt"`
+		ClosesAt          nullable[time.Time] `json:"closesAt"`
+		Anonymous         *bool               `json:"anonymous"`
+		IsTemplate        *bool               `json:"isTemplate"`
+		ResponsePolicy    *string             `json:"responsePolicy"`
+		EditWindowMinutes nullable[int]       `json:"editWindowMinutes"`
+		Questions         *[]questionInput    `json:"questions"`
 	}
 
 	if userID != questionnaire.UserId {
@@ -180,32 +178,71 @@ func (app *application) updateQuestionnaireHandler(w http.ResponseWriter, r *htt
 		questionnaire.Topic = *input.Topic
 	}
 
+	// The schedule and the edit window are removed by setting them to null.
+	if input.OpensAt.Set {
+		questionnaire.OpensAt = input.OpensAt.Value
+	}
+
+	if input.ClosesAt.Set {
+		questionnaire.ClosesAt = input.ClosesAt.Value
+	}
+
+	v := validator.New()
+
+	// Respondents must know whether they answer anonymously, so that can't change once the
+	// questionnaire has been published.
+	if input.Anonymous != nil && *input.Anonymous != questionnaire.Anonymous {
+		v.Check(questionnaire.Status == model.StatusDraft, "anonymous", "can only be changed while the questionnaire is a draft")
+		questionnaire.Anonymous = *input.Anonymous
+	}
+
+	if input.IsTemplate != nil {
+		questionnaire.IsTemplate = *input.IsTemplate
+	}
+
+	if input.ResponsePolicy != nil {
+		questionnaire.ResponsePolicy = *input.ResponsePolicy
+	}
+
+	if input.EditWindowMinutes.Set {
+		questionnaire.EditWindowMinutes = input.EditWindowMinutes.Value
+	}
+
+	// A questions list in the request body replaces every existing question.
 	if input.Questions != nil {
-		questionnaire.Questions = *input.Questions
+		questionnaire.Questions = questions(*input.Questions)
+	}
+
+	if model.ValidateQuestionnaire(v, questionnaire); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
 	}
 
 	err = app.models.Questionnaires.Update(questionnaire)
 	if err != nil {
-		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
+		switch {
+		case errors.Is(err, model.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if questionnaire.Questions == nil {
+		questionnaire.Questions, err = app.models.Questions.GetAllForQuestionnaire(questionnaire.Id)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
 	}
 
 	app.respondWithJson(w, http.StatusOK, questionnaire)
 }
 
 func (app *application) deleteQuestionnaireHandler(w http.ResponseWriter, r *http.Request) {
-	tokenString, err := app.extractToken(r)
-	if err != nil {
-		app.respondWithError(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
+	userID := app.contextGetUser(r).Id
 
-	// Получить userId из токена
-	userID, err := app.getUserIdFromToken(tokenString)
-	//fmt.Println("createQuestionnaireHandler called")
-	if err != nil {
-		app.respondWithError(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
 	vars := mux.Vars(r)
 	param := vars["questionnaireId"]
 
@@ -222,14 +259,159 @@ func (app *application) deleteQuestionnaireHandler(w http.ResponseWriter, r *htt
 	}
 
 	if userID != questionnaire.UserId {
-		app.respondWithError(w, http.StatusInternalServerError, "Cannot delete other's questionnaire")
-		return
+		// Moderators may delete any questionnaire, e.g. to take down abusive ones.
+		moderator, err := app.hasPermission(r, model.PermissionQuestionnaireModerate)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !moderator {
+			app.respondWithError(w, http.StatusForbidden, "Cannot delete other's questionnaire")
+			return
+		}
 	}
 
 	err = app.models.Questionnaires.Delete(id)
 	if err != nil {
 		app.respondWithError(w, http.StatusInternalServerError, "500 Internal Server Error")
+		return
 	}
 
 	app.respondWithJson(w, http.StatusOK, map[string]string{"result": "success"})
 }
+
+// duplicateQuestionnaireHandler copies a questionnaire the caller can see, with its questions,
+// options, logic and answer settings, into a new draft owned by the caller. Its schedule, its
+// answers and the template flag are not copied. The request body may give the copy a new topic.
+func (app *application) duplicateQuestionnaireHandler(w http.ResponseWriter, r *http.Request) {
+	source, ok := app.visibleQuestionnaire(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Topic *string `json:"topic"`
+	}
+
+	if r.ContentLength != 0 {
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+	}
+
+	questions, err := app.models.Questions.GetAllForQuestionnaire(source.Id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	questionnaire := &model.Questionnaire{
+		Topic:             source.Topic,
+		Status:            model.StatusDraft,
+		Anonymous:         source.Anonymous,
+		ResponsePolicy:    source.ResponsePolicy,
+		EditWindowMinutes: source.EditWindowMinutes,
+		Questions:         questions,
+		UserId:            app.contextGetUser(r).Id,
+	}
+
+	if input.Topic != nil {
+		questionnaire.Topic = *input.Topic
+	}
+
+	v := validator.New()
+
+	if model.ValidateQuestionnaire(v, questionnaire); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Questionnaires.Insert(questionnaire)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.respondWithJson(w, http.StatusCreated, questionnaire)
+}
+
+// ownedQuestionnaire looks up the questionnaire named by the "questionnaireId" URL parameter and
+// makes sure it belongs to the current user. If it doesn't, the matching error response is sent
+// and false is returned.
+func (app *application) ownedQuestionnaire(w http.ResponseWriter, r *http.Request) (*model.Questionnaire, bool) {
+	return app.managedQuestionnaire(w, r, false)
+}
+
+// moderatedQuestionnaire is like ownedQuestionnaire, but also lets moderators have questionnaires
+// of other users.
+func (app *application) moderatedQuestionnaire(w http.ResponseWriter, r *http.Request) (*model.Questionnaire, bool) {
+	return app.managedQuestionnaire(w, r, true)
+}
+
+func (app *application) managedQuestionnaire(w http.ResponseWriter, r *http.Request, moderate bool) (*model.Questionnaire, bool) {
+	id, err := app.readIDParam(r, "questionnaireId")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	questionnaire, err := app.models.Questionnaires.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	if questionnaire.UserId != app.contextGetUser(r).Id {
+		permitted := false
+		if moderate {
+			permitted, err = app.hasPermission(r, model.PermissionQuestionnaireModerate)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return nil, false
+			}
+		}
+
+		if !permitted {
+			app.notPermittedResponse(w, r)
+			return nil, false
+		}
+	}
+
+	return questionnaire, true
+}
+
+// visibleQuestionnaire looks up the questionnaire named by the "questionnaireId" URL parameter
+// and makes sure the current user may see it. Other users' drafts are reported as not found.
+func (app *application) visibleQuestionnaire(w http.ResponseWriter, r *http.Request) (*model.Questionnaire, bool) {
+	id, err := app.readIDParam(r, "questionnaireId")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	questionnaire, err := app.models.Questionnaires.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	if !questionnaire.VisibleTo(app.contextGetUser(r).Id) {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	return questionnaire, true
+}
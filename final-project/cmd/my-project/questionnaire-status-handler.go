@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Aminochka4/Golang/final-project/pkg/my-project/model"
+	"github.com/Aminochka4/Golang/final-project/pkg/my-project/validator"
+)
+
+// publishQuestionnaireHandler moves a draft to the published status, after which it is visible
+// to everyone and accepts answers between its opensAt and closesAt times.
+func (app *application) publishQuestionnaireHandler(w http.ResponseWriter, r *http.Request) {
+	questionnaire, ok := app.ownedQuestionnaire(w, r)
+	if !ok {
+		return
+	}
+
+	if questionnaire.Status != model.StatusDraft {
+		app.errorResponse(w, r, http.StatusConflict, "only a draft questionnaire can be published")
+		return
+	}
+
+	questions, err := app.models.Questions.GetAllForQuestionnaire(questionnaire.Id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(len(questions) > 0, "questions", "must contain at least one question")
+	if questionnaire.ClosesAt != nil {
+		v.Check(questionnaire.ClosesAt.After(time.Now()), "closesAt", "must be in the future")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	questionnaire.Status = model.StatusPublished
+
+	app.saveQuestionnaireStatus(w, r, questionnaire)
+}
+
+// closeQuestionnaireHandler stops a published questionnaire from accepting any more answers.
+// Besides its owner, moderators can close it.
+func (app *application) closeQuestionnaireHandler(w http.ResponseWriter, r *http.Request) {
+	questionnaire, ok := app.moderatedQuestionnaire(w, r)
+	if !ok {
+		return
+	}
+
+	if questionnaire.Status != model.StatusPublished {
+		app.errorResponse(w, r, http.StatusConflict, "only a published questionnaire can be closed")
+		return
+	}
+
+	now := time.Now()
+
+	questionnaire.Status = model.StatusClosed
+	if questionnaire.ClosesAt == nil || questionnaire.ClosesAt.After(now) {
+		questionnaire.ClosesAt = &now
+	}
+
+	app.saveQuestionnaireStatus(w, r, questionnaire)
+}
+
+// reopenQuestionnaireHandler publishes a questionnaire that was closed, either explicitly or
+// because its closing time passed. The closing time is cleared; a new one can be set by updating
+// the questionnaire.
+func (app *application) reopenQuestionnaireHandler(w http.ResponseWriter, r *http.Request) {
+	questionnaire, ok := app.ownedQuestionnaire(w, r)
+	if !ok {
+		return
+	}
+
+	if !questionnaire.IsClosed(time.Now()) {
+		app.errorResponse(w, r, http.StatusConflict, "only a closed questionnaire can be reopened")
+		return
+	}
+
+	questionnaire.Status = model.StatusPublished
+	questionnaire.ClosesAt = nil
+
+	app.saveQuestionnaireStatus(w, r, questionnaire)
+}
+
+func (app *application) saveQuestionnaireStatus(w http.ResponseWriter, r *http.Request, questionnaire *model.Questionnaire) {
+	err := app.models.Questionnaires.Update(questionnaire)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"questionnaire": questionnaire}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
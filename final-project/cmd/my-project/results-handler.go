@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+)
+
+// getResultsHandler returns the aggregated responses to a version of a questionnaire, the
+// current one unless ?version is given: the total number of answers, option counts for choice
+// questions and summary statistics for scale questions.
+func (app *application) getResultsHandler(w http.ResponseWriter, r *http.Request) {
+	questionnaire, ok := app.visibleQuestionnaire(w, r)
+	if !ok {
+		return
+	}
+
+	version, ok := app.readVersion(w, r, questionnaire)
+	if !ok {
+		return
+	}
+
+	results, err := app.models.Answer.Results(questionnaire.Id, version.Version, version.Questions)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"results": results}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"log"
 	"net/http"
 
+	"github.com/Aminochka4/Golang/final-project/pkg/my-project/model"
 	"github.com/gorilla/mux"
 )
 
@@ -31,39 +32,135 @@ func (app *application) routes() http.Handler {
 
 	user1.HandleFunc("/users/login", app.createAuthenticationTokenHandler).Methods("POST")
 
-	user1.HandleFunc("/users", app.getAllUsersHandler).Methods("GET")
+	user1.HandleFunc("/users/login", app.requireAuthenticatedUser(app.deleteAuthenticationTokenHandler)).Methods("DELETE")
+
+	user1.HandleFunc("/users/login/2fa", app.completeTwoFactorLoginHandler).Methods("POST")
+
+	user1.HandleFunc("/users/me/2fa", app.requireAuthenticatedUser(app.enrollTwoFactorHandler)).Methods("POST")
+
+	user1.HandleFunc("/users/me/2fa/confirm", app.requireAuthenticatedUser(app.confirmTwoFactorHandler)).Methods("POST")
+
+	user1.HandleFunc("/users/me/2fa", app.requireAuthenticatedUser(app.disableTwoFactorHandler)).Methods("DELETE")
+
+	user1.HandleFunc("/tokens/refresh", app.refreshAuthenticationTokenHandler).Methods("POST")
+
+	user1.HandleFunc("/oidc/providers", app.listProvidersHandler).Methods("GET")
+
+	user1.HandleFunc("/oidc/{provider}/login", app.oidcLoginHandler).Methods("GET")
+
+	user1.HandleFunc("/oidc/{provider}/callback", app.oidcCallbackHandler).Methods("GET")
+
+	user1.HandleFunc("/users/password-reset", app.createPasswordResetTokenHandler).Methods("POST")
+
+	user1.HandleFunc("/users/password", app.updateUserPasswordHandler).Methods("PUT")
+
+	user1.HandleFunc("/users/me", app.requireAuthenticatedUser(app.getCurrentUserHandler)).Methods("GET")
+
+	user1.HandleFunc("/users/me", app.requireAuthenticatedUser(app.updateCurrentUserHandler)).Methods("PATCH")
+
+	user1.HandleFunc("/users/me", app.requireAuthenticatedUser(app.deleteCurrentUserHandler)).Methods("DELETE")
+
+	user1.HandleFunc("/users/me/email", app.requireAuthenticatedUser(app.requestEmailChangeHandler)).Methods("POST")
+
+	user1.HandleFunc("/users/email/confirm", app.confirmEmailChangeHandler).Methods("PUT")
+
+	user1.HandleFunc("/users/email/revert", app.revertEmailChangeHandler).Methods("PUT")
+
+	user1.HandleFunc("/users/me/sessions", app.requireAuthenticatedUser(app.listSessionsHandler)).Methods("GET")
+
+	user1.HandleFunc("/users/me/sessions", app.requireAuthenticatedUser(app.deleteAllSessionsHandler)).Methods("DELETE")
+
+	user1.HandleFunc("/users/me/sessions/{sessionId:[0-9]+}", app.requireAuthenticatedUser(app.deleteSessionHandler)).Methods("DELETE")
+
+	user1.HandleFunc("/users/me/api-keys", app.requireAuthenticatedUser(app.listAPIKeysHandler)).Methods("GET")
+
+	user1.HandleFunc("/users/me/api-keys", app.requireAuthenticatedUser(app.createAPIKeyHandler)).Methods("POST")
+
+	user1.HandleFunc("/users/me/api-keys/{keyId:[0-9]+}", app.requireAuthenticatedUser(app.deleteAPIKeyHandler)).Methods("DELETE")
+
+	user1.HandleFunc("/users/me/export", app.requireAuthenticatedUser(app.createDataExportHandler)).Methods("POST")
+
+	user1.HandleFunc("/users/me/export/{exportId:[0-9]+}", app.requireAuthenticatedUser(app.getDataExportHandler)).Methods("GET")
+
+	user1.HandleFunc("/users/me/export/{exportId:[0-9]+}/download", app.requireAuthenticatedUser(app.downloadDataExportHandler)).Methods("GET")
+
+	user1.HandleFunc("/users", app.requirePermissions(model.PermissionUsersAdmin, app.getAllUsersHandler)).Methods("GET")
 
 	user1.HandleFunc("/users/{userId:[0-9]+}", app.getUserByIdHandler).Methods("GET")
 
+	//admin
+
+	admin1 := r.PathPrefix("/api/v1/admin").Subrouter()
+
+	admin1.HandleFunc("/roles", app.requirePermissions(model.PermissionUsersAdmin, app.listRolesHandler)).Methods("GET")
+
+	admin1.HandleFunc("/users/{userId:[0-9]+}/permissions", app.requirePermissions(model.PermissionUsersAdmin, app.getUserPermissionsHandler)).Methods("GET")
+
+	admin1.HandleFunc("/users/{userId:[0-9]+}/roles/{role}", app.requirePermissions(model.PermissionUsersAdmin, app.grantRoleHandler)).Methods("PUT")
+
+	admin1.HandleFunc("/users/{userId:[0-9]+}/roles/{role}", app.requirePermissions(model.PermissionUsersAdmin, app.revokeRoleHandler)).Methods("DELETE")
+
+	admin1.HandleFunc("/users/{userId:[0-9]+}/permissions/{permission}", app.requirePermissions(model.PermissionUsersAdmin, app.grantPermissionHandler)).Methods("PUT")
+
+	admin1.HandleFunc("/users/{userId:[0-9]+}/permissions/{permission}", app.requirePermissions(model.PermissionUsersAdmin, app.revokePermissionHandler)).Methods("DELETE")
+
 	//questionnaire
 
 	questionnaire1 := r.PathPrefix("/api/v1").Subrouter()
 
-	questionnaire1.HandleFunc("/questionnaire", app.createQuestionnaireHandler).Methods("POST")
+	questionnaire1.HandleFunc("/questionnaire", app.requirePermissions(model.PermissionQuestionnaireWrite, app.createQuestionnaireHandler)).Methods("POST")
 
 	questionnaire1.HandleFunc("/questionnaire", app.getAllQuestionnairesHandler).Methods("GET")
 
 	questionnaire1.HandleFunc("/questionnaire/{questionnaireId:[0-9]+}", app.getQuestionnaireHandler).Methods("GET")
 
-	questionnaire1.HandleFunc("/questionnaire/{questionnaireId:[0-9]+}", app.updateQuestionnaireHandler).Methods("PUT")
+	questionnaire1.HandleFunc("/questionnaire/{questionnaireId:[0-9]+}", app.requirePermissions(model.PermissionQuestionnaireWrite, app.updateQuestionnaireHandler)).Methods("PUT")
+
+	questionnaire1.HandleFunc("/questionnaire/{questionnaireId:[0-9]+}", app.requirePermissions(model.PermissionQuestionnaireWrite, app.deleteQuestionnaireHandler)).Methods("DELETE")
+
+	questionnaire1.HandleFunc("/questionnaire/{questionnaireId:[0-9]+}/duplicate", app.requirePermissions(model.PermissionQuestionnaireWrite, app.duplicateQuestionnaireHandler)).Methods("POST")
 
-	questionnaire1.HandleFunc("/questionnaire/{questionnaireId:[0-9]+}", app.deleteQuestionnaireHandler).Methods("DELETE")
+	questionnaire1.HandleFunc("/questionnaire/{questionnaireId:[0-9]+}/publish", app.requirePermissions(model.PermissionQuestionnaireWrite, app.publishQuestionnaireHandler)).Methods("POST")
+
+	questionnaire1.HandleFunc("/questionnaire/{questionnaireId:[0-9]+}/close", app.requirePermissions(model.PermissionQuestionnaireWrite, app.closeQuestionnaireHandler)).Methods("POST")
+
+	questionnaire1.HandleFunc("/questionnaire/{questionnaireId:[0-9]+}/reopen", app.requirePermissions(model.PermissionQuestionnaireWrite, app.reopenQuestionnaireHandler)).Methods("POST")
+
+	questionnaire1.HandleFunc("/questionnaire/{questionnaireId:[0-9]+}/questions", app.listQuestionsHandler).Methods("GET")
+
+	questionnaire1.HandleFunc("/questionnaire/{questionnaireId:[0-9]+}/questions", app.requirePermissions(model.PermissionQuestionnaireWrite, app.createQuestionHandler)).Methods("POST")
+
+	questionnaire1.HandleFunc("/questionnaire/{questionnaireId:[0-9]+}/questions/{questionId:[0-9]+}", app.getQuestionHandler).Methods("GET")
+
+	questionnaire1.HandleFunc("/questionnaire/{questionnaireId:[0-9]+}/questions/{questionId:[0-9]+}", app.requirePermissions(model.PermissionQuestionnaireWrite, app.updateQuestionHandler)).Methods("PUT")
+
+	questionnaire1.HandleFunc("/questionnaire/{questionnaireId:[0-9]+}/questions/{questionId:[0-9]+}", app.requirePermissions(model.PermissionQuestionnaireWrite, app.deleteQuestionHandler)).Methods("DELETE")
+
+	questionnaire1.HandleFunc("/questionnaire/{questionnaireId:[0-9]+}/versions", app.listVersionsHandler).Methods("GET")
+
+	questionnaire1.HandleFunc("/questionnaire/{questionnaireId:[0-9]+}/versions/diff", app.diffVersionsHandler).Methods("GET")
+
+	questionnaire1.HandleFunc("/questionnaire/{questionnaireId:[0-9]+}/versions/{version:[0-9]+}", app.getVersionHandler).Methods("GET")
 
 	//answer
 
 	answer1 := r.PathPrefix("/api/v1").Subrouter()
 
-	answer1.HandleFunc("/answer", app.createAnswerHandler).Methods("POST")
+	answer1.HandleFunc("/answer", app.requirePermissions(model.PermissionAnswerWrite, app.createAnswerHandler)).Methods("POST")
 
 	answer1.HandleFunc("/answer", app.getAllAnswersHandler).Methods("GET")
 
 	answer1.HandleFunc("/answer/{answerId:[0-9]+}", app.getAnswerHandler).Methods("GET")
 
-	answer1.HandleFunc("/answer/{answerId:[0-9]+}", app.updateAnswerHandler).Methods("PUT")
+	answer1.HandleFunc("/answer/{answerId:[0-9]+}", app.requireReceiptOrPermissions(model.PermissionAnswerWrite, app.updateAnswerHandler)).Methods("PUT")
 
-	answer1.HandleFunc("/answer/{answerId:[0-9]+}", app.deleteAnswerHandler).Methods("DELETE")
+	answer1.HandleFunc("/answer/{answerId:[0-9]+}", app.requireReceiptOrPermissions(model.PermissionAnswerWrite, app.deleteAnswerHandler)).Methods("DELETE")
 
 	answer1.HandleFunc("/questionnaire/{questionnaireId:[0-9]+}/answer", app.getAnswerByQuestionnaireHandler).Methods("GET")
 
+	answer1.HandleFunc("/questionnaire/{questionnaireId:[0-9]+}/results", app.getResultsHandler).Methods("GET")
+
+	answer1.HandleFunc("/questionnaire/{questionnaireId:[0-9]+}/export", app.requirePermissions(model.PermissionQuestionnaireRead, app.exportAnswersHandler)).Methods("GET")
+
 	return app.authenticate(r)
 }
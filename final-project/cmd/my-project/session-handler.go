@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Aminochka4/Golang/final-project/pkg/my-project/model"
+)
+
+// listSessionsHandler returns the active authentication tokens of the current user, with the
+// one the request was made with marked as current.
+func (app *application) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	sessions, err := app.models.Tokens.GetSessions(user.Id, app.contextGetToken(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"sessions": sessions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteSessionHandler revokes one of the current user's authentication tokens.
+func (app *application) deleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r, "sessionId")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Tokens.DeleteSession(app.contextGetUser(r).Id, int64(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.revoked()
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "session successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteAllSessionsHandler logs the current user out everywhere by revoking every one of their
+// authentication and refresh tokens, including the one the request was made with.
+func (app *application) deleteAllSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.models.Tokens.DeleteAllForUser(model.ScopeAuthentication, app.contextGetUser(r).Id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.revoked()
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "you have been logged out everywhere"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sessionUsage records when the sessions of signed tokens were last used. Verifying a signed
+// token doesn't touch the database, so instead of writing on every request the last use of a
+// session is written at most once per interval. The list of sessions may therefore show a last
+// use up to one interval old.
+type sessionUsage struct {
+	mu       sync.Mutex
+	written  map[int64]time.Time
+	interval time.Duration
+}
+
+func newSessionUsage(interval time.Duration) *sessionUsage {
+	s := &sessionUsage{
+		written:  make(map[int64]time.Time),
+		interval: interval,
+	}
+
+	// Forget sessions whose last write is old enough for the next use to be written anyway.
+	go func() {
+		for {
+			time.Sleep(interval)
+
+			s.mu.Lock()
+			for session, written := range s.written {
+				if time.Since(written) >= s.interval {
+					delete(s.written, session)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}()
+
+	return s
+}
+
+// due reports whether the use of a session should be written now, and if so counts it as
+// written.
+func (s *sessionUsage) due(session int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if written, ok := s.written[session]; ok && now.Sub(written) < s.interval {
+		return false
+	}
+
+	s.written[session] = now
+	return true
+}
+
+// sessionUsed records the use of the session of a signed token in the background, so that the
+// request doesn't wait for the database.
+func (app *application) sessionUsed(session int64) {
+	if app.sessionUsage == nil || !app.sessionUsage.due(session) {
+		return
+	}
+
+	app.background(func() {
+		if err := app.models.Tokens.Touch(session); err != nil {
+			app.logger.PrintError(err, map[string]string{
+				"task":    "record session use",
+				"session": strconv.FormatInt(session, 10),
+			})
+		}
+	})
+}
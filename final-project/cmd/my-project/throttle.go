@@ -0,0 +1,133 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Aminochka4/Golang/final-project/pkg/jsonlog"
+)
+
+// loginThrottle slows down password guessing. Failed logins are counted per key (a username or
+// a client IP); every failure doubles the time the key has to wait before its next attempt,
+// and after too many failures the key is locked out altogether. The counts are kept in memory,
+// so they are per server process and reset on restart.
+type loginThrottle struct {
+	mu       sync.Mutex
+	attempts map[string]*loginAttempts
+	backoff  time.Duration
+	lockout  time.Duration
+	logger   *jsonlog.Logger
+}
+
+type loginAttempts struct {
+	failures    int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+func newLoginThrottle(backoff, lockout time.Duration, logger *jsonlog.Logger) *loginThrottle {
+	t := &loginThrottle{
+		attempts: make(map[string]*loginAttempts),
+		backoff:  backoff,
+		lockout:  lockout,
+		logger:   logger,
+	}
+
+	// Forget keys that have been quiet for longer than any of their delays could last.
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+
+			t.mu.Lock()
+			for key, attempts := range t.attempts {
+				if time.Since(attempts.last) > t.lockout && time.Now().After(attempts.lockedUntil) {
+					delete(t.attempts, key)
+				}
+			}
+			t.mu.Unlock()
+		}
+	}()
+
+	return t
+}
+
+// wait returns how long the caller has to wait before another login attempt for any of the
+// keys is allowed, or 0 if it may try now.
+func (t *loginThrottle) wait(keys ...string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+
+	var longest time.Duration
+	for _, key := range keys {
+		attempts, ok := t.attempts[key]
+		if !ok {
+			continue
+		}
+
+		until := attempts.lockedUntil
+		if next := attempts.last.Add(t.delay(attempts.failures)); next.After(until) {
+			until = next
+		}
+
+		if wait := until.Sub(now); wait > longest {
+			longest = wait
+		}
+	}
+
+	return longest
+}
+
+// delay is the back-off after the given number of consecutive failures. It never exceeds the
+// lockout duration.
+func (t *loginThrottle) delay(failures int) time.Duration {
+	if failures == 0 {
+		return 0
+	}
+
+	delay := t.backoff
+	for i := 1; i < failures && delay < t.lockout; i++ {
+		delay *= 2
+	}
+
+	if delay > t.lockout {
+		delay = t.lockout
+	}
+	return delay
+}
+
+// fail records a failed attempt for key, and locks the key out every time it has failed another
+// maxFailures times in a row. A maxFailures of 0 disables the lockout, leaving just the back-off.
+func (t *loginThrottle) fail(key string, maxFailures int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	attempts, ok := t.attempts[key]
+	if !ok {
+		attempts = &loginAttempts{}
+		t.attempts[key] = attempts
+	}
+
+	attempts.failures++
+	attempts.last = time.Now()
+
+	if maxFailures > 0 && attempts.failures%maxFailures == 0 {
+		attempts.lockedUntil = attempts.last.Add(t.lockout)
+
+		t.logger.PrintInfo("login locked out", map[string]string{
+			"key":      key,
+			"failures": strconv.Itoa(attempts.failures),
+			"until":    attempts.lockedUntil.Format(time.RFC3339),
+		})
+	}
+}
+
+// reset forgets the failures of key after a successful login.
+func (t *loginThrottle) reset(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.attempts, key)
+}
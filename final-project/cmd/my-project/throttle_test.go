@@ -0,0 +1,147 @@
+package main
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Aminochka4/Golang/final-project/pkg/jsonlog"
+)
+
+const (
+	testUserKey = "username:alice"
+	testIPKey   = "ip:192.0.2.1"
+)
+
+func newTestThrottle(backoff, lockout time.Duration) *loginThrottle {
+	return newLoginThrottle(backoff, lockout, jsonlog.NewLogger(io.Discard, jsonlog.LevelInfo))
+}
+
+// locked reports whether key is currently locked out, as opposed to just backing off.
+func (t *loginThrottle) locked(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	attempts, ok := t.attempts[key]
+	return ok && attempts.lockedUntil.After(time.Now())
+}
+
+func TestLoginThrottleThresholds(t *testing.T) {
+	tests := []struct {
+		name        string
+		key         string
+		maxFailures int
+	}{
+		{name: "per user", key: testUserKey, maxFailures: 5},
+		{name: "per IP", key: testIPKey, maxFailures: 20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			throttle := newTestThrottle(time.Millisecond, time.Hour)
+
+			for i := 1; i < tt.maxFailures; i++ {
+				throttle.fail(tt.key, tt.maxFailures)
+				if throttle.locked(tt.key) {
+					t.Fatalf("locked out after %d failures, want %d", i, tt.maxFailures)
+				}
+			}
+
+			throttle.fail(tt.key, tt.maxFailures)
+			if !throttle.locked(tt.key) {
+				t.Fatalf("not locked out after %d failures", tt.maxFailures)
+			}
+			if wait := throttle.wait(testUserKey, testIPKey); wait < time.Hour-time.Minute {
+				t.Errorf("got wait %v, want the lockout of %v", wait, time.Hour)
+			}
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		throttle := newTestThrottle(time.Millisecond, time.Hour)
+
+		for i := 0; i < 50; i++ {
+			throttle.fail(testUserKey, 0)
+		}
+		if throttle.locked(testUserKey) {
+			t.Error("locked out with the lockout disabled")
+		}
+	})
+}
+
+func TestLoginThrottleDelay(t *testing.T) {
+	throttle := newTestThrottle(time.Second, 15*time.Minute)
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{10, 512 * time.Second},
+		{11, 15 * time.Minute},
+		{1000, 15 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := throttle.delay(tt.failures); got != tt.want {
+			t.Errorf("%d failures: got delay %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLoginThrottleWaitGrows(t *testing.T) {
+	throttle := newTestThrottle(time.Minute, time.Hour)
+
+	if wait := throttle.wait(testUserKey); wait != 0 {
+		t.Fatalf("got wait %v before any failure, want 0", wait)
+	}
+
+	previous := time.Duration(0)
+	for i := 1; i <= 3; i++ {
+		throttle.fail(testUserKey, 0)
+
+		wait := throttle.wait(testUserKey)
+		if want := throttle.delay(i); wait <= want-time.Second || wait > want {
+			t.Errorf("after %d failures: got wait %v, want about %v", i, wait, want)
+		}
+		if wait <= previous {
+			t.Errorf("after %d failures: wait %v didn't grow from %v", i, wait, previous)
+		}
+		previous = wait
+	}
+
+	// The longest wait of the keys applies.
+	throttle.fail(testIPKey, 0)
+	if wait := throttle.wait(testIPKey); wait > time.Minute {
+		t.Errorf("got wait %v for the IP, want at most %v", wait, time.Minute)
+	}
+	if wait := throttle.wait(testUserKey, testIPKey); wait < previous-time.Second {
+		t.Errorf("got wait %v for both keys, want about %v", wait, previous)
+	}
+}
+
+func TestLoginThrottleReset(t *testing.T) {
+	throttle := newTestThrottle(time.Minute, time.Hour)
+
+	for i := 0; i < 5; i++ {
+		throttle.fail(testUserKey, 5)
+		throttle.fail(testIPKey, 20)
+	}
+
+	throttle.reset(testUserKey)
+
+	if wait := throttle.wait(testUserKey); wait != 0 {
+		t.Errorf("got wait %v after reset, want 0", wait)
+	}
+	if throttle.locked(testUserKey) {
+		t.Error("still locked out after reset")
+	}
+
+	// A successful login only clears the username; failures from the IP still count.
+	if wait := throttle.wait(testIPKey); wait == 0 {
+		t.Error("IP failures cleared by resetting the username")
+	}
+}
@@ -2,11 +2,21 @@ package main
 
 import (
 	"errors"
+	"net"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/Aminochka4/Golang/final-project/pkg/my-project/model"
 	"github.com/Aminochka4/Golang/final-project/pkg/my-project/validator"
+	"github.com/Aminochka4/Golang/final-project/pkg/signedtoken"
+)
+
+// Authentication tokens are short-lived; clients keep their session going by trading the refresh
+// token for a new pair before the authentication token expires.
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
 )
 
 func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
@@ -31,10 +41,20 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
+	// Usernames are case-insensitive, so their failures are counted together.
+	userKey := "username:" + strings.ToLower(input.Username)
+	ipKey := "ip:" + clientIP(r)
+
+	if wait := app.loginThrottle.wait(userKey, ipKey); wait > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, wait)
+		return
+	}
+
 	user, err := app.models.Users.GetByUsername(input.Username)
 	if err != nil {
 		switch {
 		case errors.Is(err, model.ErrRecordNotFound):
+			app.failedLogin(userKey, ipKey)
 			app.invalidCredentialsResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -49,18 +69,175 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	}
 
 	if !match {
+		app.failedLogin(userKey, ipKey)
 		app.invalidCredentialsResponse(w, r)
 		return
 	}
 
-	token, err := app.models.Tokens.New(user.Id, 24*time.Hour, model.ScopeAuthentication)
+	// Only the username is forgiven: logging into one account mustn't reset the count of a
+	// client that is guessing the passwords of others.
+	app.loginThrottle.reset(userKey)
+
+	app.startSession(w, r, user)
+}
+
+// startSession logs in a user who proved who they are. Users with two-factor authentication
+// enabled get a short-lived challenge token instead, which completeTwoFactorLoginHandler trades
+// for the session together with a code.
+func (app *application) startSession(w http.ResponseWriter, r *http.Request, user *model.User) {
+	enrollment, err := app.models.TOTP.Get(user.Id)
+	if err != nil && !errors.Is(err, model.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if enrollment.Enabled() {
+		challenge, err := app.models.Tokens.New(user.Id, 5*time.Minute, model.ScopeTwoFactor)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusAccepted, envelope{"two_factor_token": challenge}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.issueSession(w, r, user)
+}
+
+// issueSession starts a session for a user and sends its tokens.
+func (app *application) issueSession(w http.ResponseWriter, r *http.Request, user *model.User) {
+	access, refresh, err := app.models.Tokens.NewSession(user.Id, accessTokenTTL, refreshTokenTTL, r.UserAgent(), clientIP(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.signAccessToken(access, user.Activated)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": access, "refresh_token": refresh}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// refreshAuthenticationTokenHandler trades a refresh token for a new authentication token and a
+// new refresh token. Each refresh token can only be used once: using it again revokes every token
+// of its session, as one of the two parties using it must have stolen it.
+func (app *application) refreshAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if model.ValidateTokenPlaintext(v, input.RefreshToken); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	access, refresh, err := app.models.Tokens.Refresh(input.RefreshToken, accessTokenTTL, refreshTokenTTL, r.UserAgent(), clientIP(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrTokenReused):
+			app.logger.PrintInfo("refresh token reused, session revoked", map[string]string{
+				"ip": clientIP(r),
+			})
+			app.revoked()
+			app.invalidAuthenticationTokenResponse(w, r)
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Rotating revoked the authentication token issued with the old refresh token.
+	app.revoked()
+
+	user, err := app.models.Users.GetById(int(access.UserID))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.signAccessToken(access, user.Activated)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": access, "refresh_token": refresh}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// signAccessToken replaces an authentication token that was just issued with a signed token for
+// the same session, when signed tokens are enabled. The session stays in the database, so that
+// it is listed, refreshed and revoked like any other.
+func (app *application) signAccessToken(access *model.Token, activated bool) error {
+	if app.keyring == nil {
+		return nil
+	}
+
+	signed, err := app.keyring.Sign(signedtoken.Claims{
+		Subject:   access.UserID,
+		Session:   access.Id,
+		Activated: activated,
+		IssuedAt:  time.Now().Unix(),
+		Expiry:    access.Expiry.Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return app.models.Tokens.Seal(access, signed)
+}
+
+// deleteAuthenticationTokenHandler logs the user out by revoking the token the request was
+// made with.
+func (app *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.models.Tokens.Delete(app.contextGetToken(r))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	app.revoked()
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "you have been logged out"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// failedLogin counts a failed login against both the username and the client IP.
+func (app *application) failedLogin(userKey, ipKey string) {
+	app.loginThrottle.fail(userKey, app.config.login.maxFailures)
+	app.loginThrottle.fail(ipKey, app.config.login.maxIPFailures)
+}
+
+// clientIP returns the address of the client that sent the request. Forwarding headers are
+// ignored, as anyone can set them.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
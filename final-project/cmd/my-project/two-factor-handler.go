@@ -0,0 +1,276 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Aminochka4/Golang/final-project/pkg/my-project/model"
+	"github.com/Aminochka4/Golang/final-project/pkg/my-project/validator"
+	"github.com/Aminochka4/Golang/final-project/pkg/totp"
+)
+
+// totpIssuer names the service in authenticator apps.
+const totpIssuer = "Questionnaires"
+
+// enrollTwoFactorHandler starts enrolling an authenticator app for the current user. The secret
+// is returned together with the otpauth:// URI apps scan as a QR code; two-factor authentication
+// is only enabled once a code from the app is confirmed.
+func (app *application) enrollTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.loadCurrentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		CurrentPassword string `json:"currentPassword"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if !app.checkCurrentPassword(w, r, user, input.CurrentPassword) {
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.TOTP.Enroll(user.Id, secret)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrAlreadyEnrolled):
+			app.errorResponse(w, r, http.StatusConflict, "two-factor authentication is already enabled")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{
+		"secret":          secret,
+		"provisioningUri": totp.ProvisioningURI(totpIssuer, user.Username, secret),
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// confirmTwoFactorHandler enables two-factor authentication with a code from the authenticator
+// app that was just enrolled, and returns the recovery codes. They are shown only this once.
+func (app *application) confirmTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	enrollment, err := app.models.TOTP.Get(user.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if enrollment.Enabled() {
+		app.errorResponse(w, r, http.StatusConflict, "two-factor authentication is already enabled")
+		return
+	}
+
+	step, ok := totp.Validate(enrollment.Secret, input.Code, time.Now(), 1)
+	if !ok {
+		app.failedValidationResponse(w, r, map[string]string{"code": "invalid code"})
+		return
+	}
+
+	codes, err := app.models.TOTP.Confirm(user.Id, step)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrAlreadyEnrolled):
+			app.errorResponse(w, r, http.StatusConflict, "two-factor authentication is already enabled")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"recoveryCodes": codes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// disableTwoFactorHandler turns two-factor authentication off. It takes both the current
+// password and a code, or a recovery code.
+func (app *application) disableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.loadCurrentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		CurrentPassword string `json:"currentPassword"`
+		Code            string `json:"code"`
+		RecoveryCode    string `json:"recoveryCode"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if !app.checkCurrentPassword(w, r, user, input.CurrentPassword) {
+		return
+	}
+
+	if !app.checkSecondFactor(w, r, user.Id, input.Code, input.RecoveryCode) {
+		return
+	}
+
+	err = app.models.TOTP.Delete(user.Id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication has been disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// completeTwoFactorLoginHandler trades the challenge token of a login that passed the password
+// check, together with a code or a recovery code, for a session.
+func (app *application) completeTwoFactorLoginHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recoveryCode"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if model.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(model.ScopeTwoFactor, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired two-factor token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.checkSecondFactor(w, r, user.Id, input.Code, input.RecoveryCode) {
+		return
+	}
+
+	err = app.models.Tokens.Delete(input.TokenPlaintext)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.issueSession(w, r, user)
+}
+
+// checkCurrentPassword makes sure password is the user's current password. If it isn't, a
+// failed validation response is sent and false is returned.
+func (app *application) checkCurrentPassword(w http.ResponseWriter, r *http.Request, user *model.User, password string) bool {
+	match := false
+	if password != "" {
+		var err error
+		match, err = user.Password.Matches(password)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return false
+		}
+	}
+
+	if !match {
+		app.failedValidationResponse(w, r, map[string]string{"currentPassword": "must be your current password"})
+		return false
+	}
+
+	return true
+}
+
+// checkSecondFactor accepts either a code from the user's authenticator app, which can't be
+// used twice, or one of their recovery codes. Failures count towards the login throttle, as the
+// codes are short enough to guess. If the check fails, the matching error response is sent and
+// false is returned.
+func (app *application) checkSecondFactor(w http.ResponseWriter, r *http.Request, userID int64, code, recoveryCode string) bool {
+	userKey := "two-factor:" + strconv.FormatInt(userID, 10)
+	ipKey := "ip:" + clientIP(r)
+
+	if wait := app.loginThrottle.wait(userKey, ipKey); wait > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, wait)
+		return false
+	}
+
+	enrollment, err := app.models.TOTP.Get(userID)
+	if err != nil && !errors.Is(err, model.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if !enrollment.Enabled() {
+		app.errorResponse(w, r, http.StatusConflict, "two-factor authentication is not enabled")
+		return false
+	}
+
+	ok := false
+	switch {
+	case recoveryCode != "":
+		ok, err = app.models.TOTP.UseRecoveryCode(userID, recoveryCode)
+	default:
+		if step, valid := totp.Validate(enrollment.Secret, code, time.Now(), 1); valid {
+			ok, err = app.models.TOTP.UseStep(userID, step)
+		}
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if !ok {
+		app.failedLogin(userKey, ipKey)
+		app.failedValidationResponse(w, r, map[string]string{"code": "invalid code"})
+		return false
+	}
+
+	app.loginThrottle.reset(userKey)
+	return true
+}
@@ -44,7 +44,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		Name:     input.Name,
 		Surname:  input.Surname,
 		Username: input.Username,
-		Email:    input.Email,
+		Email:    model.NormalizeEmail(input.Email),
 	}
 
 	err = user.Password.Set(input.Password)
@@ -69,6 +69,9 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		case errors.Is(err, model.ErrDuplicateEmail):
 			v.AddError("email", "a user with this email address already exists")
 
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, model.ErrDuplicateUsername):
+			v.AddError("username", "a user with this username already exists")
 			app.failedValidationResponse(w, r, v.Errors)
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -76,29 +79,27 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.models.Permissions.AddForUser(user.Id, "questionnaire:done")
+	token, err := app.models.Tokens.New(user.Id, 3*24*time.Hour, model.ScopeActivation)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	token, err := app.models.Tokens.New(user.Id, 3*24*time.Hour, model.ScopeActivation)
+	app.sendEmail(user.Email, "user_welcome.tmpl", map[string]interface{}{
+		"activationToken": token.Plaintext,
+		"userID":          user.Id,
+		"name":            user.Name,
+	})
+
+	// The activation token is only sent by email, so the response is accepted rather than
+	// created: the account can't be used until the email arrives.
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
-		return
-	}
-
-	var res struct {
-		Token *string     `json:"token"`
-		User  *model.User `json:"user"`
 	}
-
-	res.Token = &token.Plaintext
-	res.User = user
-
-	app.writeJSON(w, http.StatusCreated, envelope{"user": res}, nil)
 }
 
+// getAllUsersHandler lists every user with their private details, and is only for admins.
 func (app *application) getAllUsersHandler(w http.ResponseWriter, r *http.Request) {
 	users, err := app.models.Users.GetAll()
 	if err != nil {
@@ -108,6 +109,8 @@ func (app *application) getAllUsersHandler(w http.ResponseWriter, r *http.Reques
 	app.respondWithJson(w, http.StatusOK, users)
 }
 
+// getUserByIdHandler returns the public profile of a user, with the surname and email only if
+// the user made them visible to the caller.
 func (app *application) getUserByIdHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	param := vars["userId"]
@@ -119,13 +122,32 @@ func (app *application) getUserByIdHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	user, err := app.models.Users.GetById(id)
+	profile, err := app.models.Users.GetProfile(int64(id), app.contextGetUser(r))
 
 	if err != nil {
-		app.respondWithError(w, http.StatusNotFound, "404 Not Found")
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.respondWithError(w, http.StatusNotFound, "404 Not Found")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
-	app.respondWithJson(w, http.StatusOK, user)
+	app.respondWithJson(w, http.StatusOK, profile)
+}
+
+// getCurrentUserHandler returns everything about the current user, including the visibility of
+// their profile fields.
+func (app *application) getCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.loadCurrentUser(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
 
 func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Request) {
@@ -184,3 +206,175 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 
 	app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
 }
+
+// updateCurrentUserHandler lets the current user change their own profile. Changing the
+// username or the password requires the current password, and a new password logs out every
+// other session. A version in the body must match the stored one, so that a client editing a
+// stale copy of the profile gets a conflict instead of overwriting newer changes.
+func (app *application) updateCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.loadCurrentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name              *string `json:"name"`
+		Surname           *string `json:"surname"`
+		Username          *string `json:"username"`
+		Password          *string `json:"password"`
+		CurrentPassword   string  `json:"currentPassword"`
+		SurnameVisibility *string `json:"surnameVisibility"`
+		EmailVisibility   *string `json:"emailVisibility"`
+		Version           *int    `json:"version"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Version != nil && *input.Version != user.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	if input.Username != nil || input.Password != nil {
+		match := false
+		if input.CurrentPassword != "" {
+			match, err = user.Password.Matches(input.CurrentPassword)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+
+		if !match {
+			v.AddError("currentPassword", "must be your current password to change the username or password")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+	}
+
+	if input.Name != nil {
+		user.Name = *input.Name
+	}
+
+	if input.Surname != nil {
+		user.Surname = *input.Surname
+	}
+
+	if input.Username != nil {
+		user.Username = *input.Username
+	}
+
+	if input.SurnameVisibility != nil {
+		user.SurnameVisibility = *input.SurnameVisibility
+	}
+
+	if input.EmailVisibility != nil {
+		user.EmailVisibility = *input.EmailVisibility
+	}
+
+	if input.Password != nil {
+		err = user.Password.Set(*input.Password)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if model.ValidateUser(v, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrDuplicateUsername):
+			v.AddError("username", "a user with this username already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, model.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if input.Password != nil {
+		err = app.models.Tokens.DeleteOtherSessions(user.Id, app.contextGetToken(r))
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.revoked()
+
+		app.sendEmail(user.Email, "password_changed.tmpl", map[string]interface{}{
+			"name": user.Name,
+		})
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteCurrentUserHandler deletes the current user's account, together with their
+// questionnaires, answers and tokens. The current password must be sent to confirm it.
+func (app *application) deleteCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.loadCurrentUser(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	match := false
+	if input.Password != "" {
+		match, err = user.Password.Matches(input.Password)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if !match {
+		app.failedValidationResponse(w, r, map[string]string{"password": "must be your current password"})
+		return
+	}
+
+	// Deleting the user deletes their tokens too, but revoking the sessions first denies any
+	// signed tokens that are still around.
+	err = app.models.Tokens.DeleteAllForUser(model.ScopeAuthentication, user.Id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.revoked()
+
+	err = app.models.Users.Delete(int(user.Id))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "your account has been deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Aminochka4/Golang/final-project/pkg/my-project/model"
+	"github.com/Aminochka4/Golang/final-project/pkg/my-project/validator"
+)
+
+// listVersionsHandler returns every version of a questionnaire with the number of answers given
+// against it.
+func (app *application) listVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	questionnaire, ok := app.visibleQuestionnaire(w, r)
+	if !ok {
+		return
+	}
+
+	versions, err := app.models.Questionnaires.GetVersions(questionnaire.Id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"versions": versions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getVersionHandler returns a single version of a questionnaire together with its questions.
+func (app *application) getVersionHandler(w http.ResponseWriter, r *http.Request) {
+	questionnaire, ok := app.visibleQuestionnaire(w, r)
+	if !ok {
+		return
+	}
+
+	number, err := app.readIDParam(r, "version")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	version, err := app.models.Questionnaires.GetVersion(questionnaire.Id, number)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"version": version}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// diffVersionsHandler compares the versions given by ?from and ?to. They default to the version
+// before the current one and the current one.
+func (app *application) diffVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	questionnaire, ok := app.visibleQuestionnaire(w, r)
+	if !ok {
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	from := app.readInt(qs, "from", questionnaire.Version-1, v)
+	to := app.readInt(qs, "to", questionnaire.Version, v)
+
+	v.Check(from >= 1 && from <= questionnaire.Version, "from", "must be an existing version")
+	v.Check(to >= 1 && to <= questionnaire.Version, "to", "must be an existing version")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	versions := make([]*model.QuestionnaireVersion, 0, 2)
+	for _, number := range []int{from, to} {
+		version, err := app.models.Questionnaires.GetVersion(questionnaire.Id, number)
+		if err != nil {
+			switch {
+			case errors.Is(err, model.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		versions = append(versions, version)
+	}
+
+	diff := model.DiffVersions(versions[0], versions[1])
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"diff": diff}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readVersion reads the ?version query parameter, defaulting to the current version of the
+// questionnaire, and loads that version. It sends an error response and returns false if the
+// parameter is invalid or the version doesn't exist.
+func (app *application) readVersion(w http.ResponseWriter, r *http.Request, questionnaire *model.Questionnaire) (*model.QuestionnaireVersion, bool) {
+	v := validator.New()
+
+	number := app.readInt(r.URL.Query(), "version", questionnaire.Version, v)
+	if v.Check(number >= 1, "version", "must be greater than zero"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, false
+	}
+
+	version, err := app.models.Questionnaires.GetVersion(questionnaire.Id, number)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return version, true
+}
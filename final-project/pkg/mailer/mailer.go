@@ -0,0 +1,150 @@
+// Package mailer renders emails from the embedded templates and hands them to an Outbox, which
+// either delivers them over SMTP or writes them to a directory.
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"text/template"
+	"time"
+)
+
+// templateFS holds the email templates. Every template defines a "subject", a "plainBody" and
+// an "htmlBody" block.
+//
+//go:embed "templates"
+var templateFS embed.FS
+
+// Outbox takes a complete RFC 5322 message and sends it on its way.
+type Outbox interface {
+	Deliver(from string, to []string, message []byte) error
+}
+
+type Mailer struct {
+	outbox Outbox
+	sender *mail.Address
+}
+
+// New returns a Mailer that sends from the sender address, e.g. "Questionnaires
+// <no-reply@example.com>".
+func New(outbox Outbox, sender string) (*Mailer, error) {
+	address, err := mail.ParseAddress(sender)
+	if err != nil {
+		return nil, fmt.Errorf("mailer: invalid sender: %w", err)
+	}
+
+	return &Mailer{outbox: outbox, sender: address}, nil
+}
+
+// Send renders templateFile with data and delivers the result to recipient. Delivery is tried
+// up to three times, as SMTP servers are known to refuse the odd connection.
+func (m *Mailer) Send(recipient, templateFile string, data interface{}) error {
+	to, err := mail.ParseAddress(recipient)
+	if err != nil {
+		return fmt.Errorf("mailer: invalid recipient: %w", err)
+	}
+
+	subject, plainBody, htmlBody, err := render(templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	message, err := m.compose(to, subject, plainBody, htmlBody)
+	if err != nil {
+		return err
+	}
+
+	for i := 1; i <= 3; i++ {
+		err = m.outbox.Deliver(m.sender.Address, []string{to.Address}, message)
+		if err == nil {
+			return nil
+		}
+
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	return err
+}
+
+func render(templateFile string, data interface{}) (subject, plainBody, htmlBody string, err error) {
+	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	var buf bytes.Buffer
+
+	if err = tmpl.ExecuteTemplate(&buf, "subject", data); err != nil {
+		return "", "", "", err
+	}
+	subject = buf.String()
+
+	buf.Reset()
+	if err = tmpl.ExecuteTemplate(&buf, "plainBody", data); err != nil {
+		return "", "", "", err
+	}
+	plainBody = buf.String()
+
+	// The HTML body is parsed again with html/template, so that the data is escaped.
+	htmlTmpl, err := htmltemplate.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	buf.Reset()
+	if err = htmlTmpl.ExecuteTemplate(&buf, "htmlBody", data); err != nil {
+		return "", "", "", err
+	}
+	htmlBody = buf.String()
+
+	return subject, plainBody, htmlBody, nil
+}
+
+// compose builds a multipart/alternative message with a plain text and an HTML part.
+func (m *Mailer) compose(to *mail.Address, subject, plainBody, htmlBody string) ([]byte, error) {
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=UTF-8", plainBody},
+		{"text/html; charset=UTF-8", htmlBody},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", m.sender.String())
+	fmt.Fprintf(&message, "To: %s\r\n", to.String())
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("UTF-8", subject))
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&message, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", parts.Boundary())
+	message.Write(body.Bytes())
+
+	return message.Bytes(), nil
+}
@@ -0,0 +1,46 @@
+package mailer
+
+import (
+	"fmt"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SMTPOutbox delivers messages through an SMTP server. Without a username it sends without
+// authenticating.
+type SMTPOutbox struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+}
+
+func (o SMTPOutbox) Deliver(from string, to []string, message []byte) error {
+	var auth smtp.Auth
+	if o.Username != "" {
+		auth = smtp.PlainAuth("", o.Username, o.Password, o.Host)
+	}
+
+	return smtp.SendMail(fmt.Sprintf("%s:%d", o.Host, o.Port), auth, from, to, message)
+}
+
+// FileOutbox writes every message to its own .eml file in Dir instead of sending it, for use
+// during development and in tests. The files can be opened with any mail client.
+type FileOutbox struct {
+	Dir string
+}
+
+func (o FileOutbox) Deliver(from string, to []string, message []byte) error {
+	err := os.MkdirAll(o.Dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	recipient := strings.NewReplacer("@", "_at_", "/", "_", string(filepath.Separator), "_").Replace(strings.Join(to, ","))
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), recipient)
+
+	return os.WriteFile(filepath.Join(o.Dir, name), message, 0o644)
+}
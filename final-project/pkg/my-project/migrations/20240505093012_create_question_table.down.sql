@@ -0,0 +1,11 @@
+ALTER TABLE questionnaire ADD COLUMN IF NOT EXISTS questions text;
+
+UPDATE questionnaire
+SET questions = (
+    SELECT string_agg(question.text, E'\n' ORDER BY question.position)
+    FROM question
+    WHERE question.questionnaireId = questionnaire.id
+);
+
+DROP TABLE IF EXISTS question_option;
+DROP TABLE IF EXISTS question;
@@ -0,0 +1,38 @@
+CREATE TABLE IF NOT EXISTS question
+(
+    id              bigserial PRIMARY KEY,
+    createdAt       timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updatedAt       timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    questionnaireId bigint                      NOT NULL REFERENCES questionnaire (id) ON DELETE CASCADE,
+    position        integer                     NOT NULL,
+    type            text                        NOT NULL,
+    text            text                        NOT NULL,
+    required        bool                        NOT NULL DEFAULT false,
+    scaleMin        integer,
+    scaleMax        integer,
+    UNIQUE (questionnaireId, position) DEFERRABLE INITIALLY DEFERRED
+);
+
+CREATE TABLE IF NOT EXISTS question_option
+(
+    id         bigserial PRIMARY KEY,
+    questionId bigint  NOT NULL REFERENCES question (id) ON DELETE CASCADE,
+    position   integer NOT NULL,
+    text       text    NOT NULL
+);
+
+-- Questionnaires used to keep their questions as free text. Keep that text as a single long
+-- text question, which is also created for questionnaires that only have answers, so that the
+-- answers can be moved to it.
+INSERT INTO question (questionnaireId, position, type, text)
+SELECT questionnaire.id, 1, 'long_text', COALESCE(NULLIF(btrim(questionnaire.questions), ''), 'Answer')
+FROM questionnaire
+WHERE NULLIF(btrim(questionnaire.questions), '') IS NOT NULL
+   OR EXISTS (
+        SELECT 1 FROM answer
+        WHERE answer.questionnaireId = questionnaire.id AND NULLIF(btrim(answer.answer), '') IS NOT NULL
+    );
+
+ALTER TABLE questionnaire DROP COLUMN IF EXISTS questions;
+
+SELECT * FROM question;
@@ -0,0 +1,11 @@
+ALTER TABLE answer ADD COLUMN IF NOT EXISTS answer text;
+
+UPDATE answer
+SET answer = (
+    SELECT string_agg(answer_response.text, E'\n' ORDER BY question.position)
+    FROM answer_response
+        INNER JOIN question ON question.id = answer_response.questionId
+    WHERE answer_response.answerId = answer.id AND answer_response.text IS NOT NULL
+);
+
+DROP TABLE IF EXISTS answer_response;
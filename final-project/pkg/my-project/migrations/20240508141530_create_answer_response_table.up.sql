@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS answer_response
+(
+    id         bigserial PRIMARY KEY,
+    answerId   bigint   NOT NULL REFERENCES answer (id) ON DELETE CASCADE,
+    questionId bigint   NOT NULL REFERENCES question (id) ON DELETE CASCADE,
+    optionIds  bigint[] NOT NULL DEFAULT '{}',
+    number     integer,
+    text       text,
+    UNIQUE (answerId, questionId)
+);
+
+CREATE INDEX IF NOT EXISTS answer_response_questionId_idx ON answer_response (questionId);
+
+-- Answers used to be free text. Move that text to a response to the long text question the
+-- previous migration made of the questionnaire's old questions.
+INSERT INTO answer_response (answerId, questionId, text)
+SELECT answer.id, question.id, answer.answer
+FROM answer
+    INNER JOIN question ON question.questionnaireId = answer.questionnaireId
+        AND question.position = 1 AND question.type = 'long_text'
+WHERE NULLIF(btrim(answer.answer), '') IS NOT NULL
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE answer DROP COLUMN IF EXISTS answer;
+
+SELECT * FROM answer_response;
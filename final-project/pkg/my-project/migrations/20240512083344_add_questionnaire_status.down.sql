@@ -0,0 +1,4 @@
+ALTER TABLE questionnaire
+    DROP COLUMN IF EXISTS status,
+    DROP COLUMN IF EXISTS opensAt,
+    DROP COLUMN IF EXISTS closesAt;
@@ -0,0 +1,9 @@
+-- Existing questionnaires have been accepting answers all along, so they start out published.
+-- New questionnaires start out as drafts.
+ALTER TABLE questionnaire
+    ADD COLUMN IF NOT EXISTS status   text NOT NULL DEFAULT 'published'
+        CHECK (status IN ('draft', 'published', 'closed')),
+    ADD COLUMN IF NOT EXISTS opensAt  timestamp(0) with time zone,
+    ADD COLUMN IF NOT EXISTS closesAt timestamp(0) with time zone;
+
+ALTER TABLE questionnaire ALTER COLUMN status SET DEFAULT 'draft';
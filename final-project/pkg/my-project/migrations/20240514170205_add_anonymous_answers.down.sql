@@ -0,0 +1,3 @@
+ALTER TABLE answer DROP COLUMN IF EXISTS receiptHash;
+
+ALTER TABLE questionnaire DROP COLUMN IF EXISTS anonymous;
@@ -0,0 +1,5 @@
+ALTER TABLE questionnaire ADD COLUMN IF NOT EXISTS anonymous bool NOT NULL DEFAULT false;
+
+-- Answers to anonymous questionnaires don't store the respondent. The respondent proves
+-- ownership with a receipt instead, of which only the SHA-256 hash is kept.
+ALTER TABLE answer ADD COLUMN IF NOT EXISTS receiptHash bytea UNIQUE;
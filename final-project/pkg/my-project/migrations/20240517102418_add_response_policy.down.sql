@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS questionnaire_respondent;
+
+ALTER TABLE questionnaire
+    DROP COLUMN IF EXISTS responsePolicy,
+    DROP COLUMN IF EXISTS editWindowMinutes;
@@ -0,0 +1,26 @@
+-- Existing questionnaires have always accepted any number of answers per user, so they keep
+-- doing that. New questionnaires default to a single answer per user.
+ALTER TABLE questionnaire
+    ADD COLUMN IF NOT EXISTS responsePolicy    text NOT NULL DEFAULT 'multiple'
+        CHECK (responsePolicy IN ('single', 'multiple')),
+    ADD COLUMN IF NOT EXISTS editWindowMinutes integer CHECK (editWindowMinutes > 0);
+
+ALTER TABLE questionnaire ALTER COLUMN responsePolicy SET DEFAULT 'single';
+
+-- questionnaire_respondent counts the answers each user gave to a questionnaire without linking
+-- them to the answers themselves, so it works for anonymous questionnaires as well. Its primary
+-- key is what enforces the single response policy.
+CREATE TABLE IF NOT EXISTS questionnaire_respondent
+(
+    questionnaireId bigint  NOT NULL REFERENCES questionnaire (id) ON DELETE CASCADE,
+    userId          bigint  NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    responses       integer NOT NULL DEFAULT 1 CHECK (responses >= 0),
+    PRIMARY KEY (questionnaireId, userId)
+);
+
+INSERT INTO questionnaire_respondent (questionnaireId, userId, responses)
+SELECT questionnaireId, userId, count(*)
+FROM answer
+WHERE userId IS NOT NULL
+GROUP BY questionnaireId, userId
+ON CONFLICT DO NOTHING;
@@ -0,0 +1 @@
+ALTER TABLE question DROP COLUMN IF EXISTS logic;
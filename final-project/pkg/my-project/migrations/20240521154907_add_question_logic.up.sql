@@ -0,0 +1,3 @@
+-- Display conditions and skip rules of a question. They refer to other questions by position,
+-- so they are renumbered whenever questions are inserted, moved or deleted.
+ALTER TABLE question ADD COLUMN IF NOT EXISTS logic jsonb;
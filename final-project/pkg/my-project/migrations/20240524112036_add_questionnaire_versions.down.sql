@@ -0,0 +1,17 @@
+ALTER TABLE answer DROP COLUMN IF EXISTS version;
+
+-- Only the current version of every questionnaire can be kept.
+DELETE FROM question
+USING questionnaire
+WHERE questionnaire.id = question.questionnaireId AND question.version <> questionnaire.version;
+
+ALTER TABLE question DROP CONSTRAINT IF EXISTS question_questionnaireid_version_position_key;
+ALTER TABLE question
+    ADD CONSTRAINT question_questionnaireid_position_key
+        UNIQUE (questionnaireId, position) DEFERRABLE INITIALLY DEFERRED;
+
+ALTER TABLE question DROP COLUMN IF EXISTS version;
+
+DROP TABLE IF EXISTS questionnaire_version;
+
+ALTER TABLE questionnaire DROP COLUMN IF EXISTS version;
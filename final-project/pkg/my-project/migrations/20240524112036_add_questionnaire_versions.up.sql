@@ -0,0 +1,28 @@
+-- Once a questionnaire has answers its questions are no longer edited in place. Editing it
+-- creates a new version instead, and the questions of older versions are kept for the answers
+-- that were given to them.
+ALTER TABLE questionnaire ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1;
+
+CREATE TABLE IF NOT EXISTS questionnaire_version
+(
+    questionnaireId bigint                      NOT NULL REFERENCES questionnaire (id) ON DELETE CASCADE,
+    version         integer                     NOT NULL,
+    createdAt       timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    topic           text                        NOT NULL,
+    PRIMARY KEY (questionnaireId, version)
+);
+
+-- The topic of questionnaires created before it was required may be NULL.
+INSERT INTO questionnaire_version (questionnaireId, version, createdAt, topic)
+SELECT id, version, createdAt, COALESCE(topic, '')
+FROM questionnaire
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE question ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1;
+
+ALTER TABLE question DROP CONSTRAINT IF EXISTS question_questionnaireid_position_key;
+ALTER TABLE question
+    ADD CONSTRAINT question_questionnaireid_version_position_key
+        UNIQUE (questionnaireId, version, position) DEFERRABLE INITIALLY DEFERRED;
+
+ALTER TABLE answer ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1;
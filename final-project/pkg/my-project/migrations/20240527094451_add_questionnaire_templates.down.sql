@@ -0,0 +1 @@
+ALTER TABLE questionnaire DROP COLUMN IF EXISTS isTemplate;
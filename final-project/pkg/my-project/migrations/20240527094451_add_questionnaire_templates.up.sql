@@ -0,0 +1,3 @@
+-- Templates are listed in a gallery of their own and can be seen, and duplicated, by everyone
+-- even while they are drafts.
+ALTER TABLE questionnaire ADD COLUMN IF NOT EXISTS isTemplate bool NOT NULL DEFAULT false;
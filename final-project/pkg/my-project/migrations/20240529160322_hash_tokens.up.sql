@@ -0,0 +1,10 @@
+-- Tokens are stored as their SHA-256 hash instead of in plain text. Existing tokens keep working
+-- because their hash is computed from the stored plain text.
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS hash bytea;
+
+UPDATE tokens SET hash = sha256(convert_to(plaintext, 'UTF8'));
+
+ALTER TABLE tokens DROP CONSTRAINT IF EXISTS tokens_pkey;
+ALTER TABLE tokens DROP COLUMN IF EXISTS plaintext;
+ALTER TABLE tokens ALTER COLUMN hash SET NOT NULL;
+ALTER TABLE tokens ADD PRIMARY KEY (hash);
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS tokens_user_id_idx;
+
+ALTER TABLE tokens
+    DROP COLUMN IF EXISTS id,
+    DROP COLUMN IF EXISTS createdAt,
+    DROP COLUMN IF EXISTS lastUsedAt,
+    DROP COLUMN IF EXISTS userAgent,
+    DROP COLUMN IF EXISTS ip;
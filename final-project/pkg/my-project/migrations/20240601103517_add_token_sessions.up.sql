@@ -0,0 +1,10 @@
+-- Authentication tokens double as sessions, which their users can list and revoke. The id
+-- identifies a session without revealing its hash.
+ALTER TABLE tokens
+    ADD COLUMN IF NOT EXISTS id         bigserial UNIQUE,
+    ADD COLUMN IF NOT EXISTS createdAt  timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    ADD COLUMN IF NOT EXISTS lastUsedAt timestamp(0) with time zone,
+    ADD COLUMN IF NOT EXISTS userAgent  text                        NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS ip         text                        NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS tokens_user_id_idx ON tokens (user_id);
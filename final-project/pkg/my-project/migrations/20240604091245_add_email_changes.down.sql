@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS email_changes;
+
+ALTER TABLE users
+    DROP CONSTRAINT IF EXISTS users_email_key;
+
+DELETE FROM tokens WHERE scope IN ('email-change', 'email-revert');
@@ -0,0 +1,21 @@
+-- Email addresses are stored trimmed and lower-cased, and no two users may share one. Existing
+-- duplicates have to be resolved by hand before this migration can run.
+UPDATE users SET email = lower(trim(email));
+
+ALTER TABLE users
+    ADD CONSTRAINT users_email_key UNIQUE (email);
+
+-- A change of email address only takes effect once the new address is confirmed. The old
+-- address is sent a token that reverts the change.
+CREATE TABLE IF NOT EXISTS email_changes
+(
+    id          bigserial PRIMARY KEY,
+    userId      bigint                      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    oldEmail    text                        NOT NULL,
+    newEmail    text                        NOT NULL,
+    createdAt   timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    confirmedAt timestamp(0) with time zone,
+    revertedAt  timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS email_changes_userId_idx ON email_changes (userId);
@@ -0,0 +1,7 @@
+DELETE FROM tokens WHERE scope = 'refresh';
+
+DROP INDEX IF EXISTS tokens_familyId_idx;
+
+ALTER TABLE tokens
+    DROP COLUMN IF EXISTS familyId,
+    DROP COLUMN IF EXISTS rotatedAt;
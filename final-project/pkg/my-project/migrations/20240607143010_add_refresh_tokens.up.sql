@@ -0,0 +1,9 @@
+-- Refresh tokens are rotated on every use. A login starts a family, named by the id of its
+-- first refresh token, that every later refresh token and every access token issued with them
+-- belongs to. Rotated refresh tokens are kept until they expire, so that their reuse can be
+-- detected and the whole family revoked.
+ALTER TABLE tokens
+    ADD COLUMN IF NOT EXISTS familyId  bigint,
+    ADD COLUMN IF NOT EXISTS rotatedAt timestamp(0) with time zone;
+
+CREATE INDEX IF NOT EXISTS tokens_familyId_idx ON tokens (familyId);
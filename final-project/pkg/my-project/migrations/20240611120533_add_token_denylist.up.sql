@@ -0,0 +1,7 @@
+-- Signed access tokens are verified without looking them up, so revoking one means remembering
+-- its session until the token would have expired anyway.
+CREATE TABLE IF NOT EXISTS token_denylist
+(
+    sessionId bigint PRIMARY KEY,
+    expiry    timestamp(0) with time zone NOT NULL
+);
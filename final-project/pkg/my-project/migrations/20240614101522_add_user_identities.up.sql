@@ -0,0 +1,23 @@
+-- Accounts at OpenID Connect providers that users signed in with, identified by the provider's
+-- subject for them.
+CREATE TABLE IF NOT EXISTS user_identities
+(
+    provider  text                        NOT NULL,
+    subject   text                        NOT NULL,
+    userId    bigint                      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    email     text                        NOT NULL DEFAULT '',
+    createdAt timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (provider, subject)
+);
+
+CREATE INDEX IF NOT EXISTS user_identities_userId_idx ON user_identities (userId);
+
+-- Logins that were sent to a provider and haven't come back yet, by the hash of their state.
+CREATE TABLE IF NOT EXISTS oidc_logins
+(
+    stateHash    bytea PRIMARY KEY,
+    provider     text                        NOT NULL,
+    nonce        text                        NOT NULL,
+    codeVerifier text                        NOT NULL,
+    expiry       timestamp(0) with time zone NOT NULL
+);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS recovery_codes;
+DROP TABLE IF EXISTS user_totp;
+
+DELETE FROM tokens WHERE scope = 'two-factor';
@@ -0,0 +1,19 @@
+-- Authenticator apps enrolled for two-factor authentication. An enrollment only counts once it
+-- is confirmed with a code; lastStep is the time step of the last code accepted, so that no code
+-- is accepted twice.
+CREATE TABLE IF NOT EXISTS user_totp
+(
+    userId      bigint PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    secret      text                        NOT NULL,
+    createdAt   timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    confirmedAt timestamp(0) with time zone,
+    lastStep    bigint                      NOT NULL DEFAULT 0
+);
+
+-- One-time recovery codes for users who lost their authenticator, by their SHA-256 hash.
+CREATE TABLE IF NOT EXISTS recovery_codes
+(
+    userId   bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    codeHash bytea  NOT NULL,
+    PRIMARY KEY (userId, codeHash)
+);
@@ -0,0 +1,17 @@
+-- Long-lived keys for service accounts and integrations. Each key is limited to the permission
+-- codes it was created with, which must be a subset of its user's. Only the hash of a key is
+-- stored; prefix is its start, shown so that users can tell their keys apart.
+CREATE TABLE IF NOT EXISTS api_keys
+(
+    id          bigserial PRIMARY KEY,
+    userId      bigint                      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name        text                        NOT NULL,
+    prefix      text                        NOT NULL,
+    hash        bytea                       NOT NULL UNIQUE,
+    permissions text[]                      NOT NULL DEFAULT '{}',
+    createdAt   timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    expiry      timestamp(0) with time zone,
+    lastUsedAt  timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS api_keys_userId_idx ON api_keys (userId);
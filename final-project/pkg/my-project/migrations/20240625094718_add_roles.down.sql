@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS users_roles;
+DROP TABLE IF EXISTS roles_permissions;
+DROP TABLE IF EXISTS roles;
+
+DELETE FROM permissions
+WHERE code IN ('answer:write', 'questionnaire:moderate', 'users:admin');
+
+ALTER TABLE permissions
+    DROP CONSTRAINT IF EXISTS permissions_code_key;
@@ -0,0 +1,53 @@
+-- Users get permissions from their roles as well as directly. Every existing user becomes a
+-- member, which they were meant to be all along: registration granted a permission that was
+-- never seeded.
+ALTER TABLE permissions
+    ADD CONSTRAINT permissions_code_key UNIQUE (code);
+
+INSERT INTO permissions (code)
+VALUES ('answer:write'),
+       ('questionnaire:moderate'),
+       ('users:admin')
+ON CONFLICT (code) DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS roles
+(
+    id   BIGSERIAL PRIMARY KEY,
+    code TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS roles_permissions
+(
+    role_id       BIGINT NOT NULL REFERENCES roles ON DELETE CASCADE,
+    permission_id BIGINT NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE TABLE IF NOT EXISTS users_roles
+(
+    user_id BIGINT NOT NULL REFERENCES users ON DELETE CASCADE,
+    role_id BIGINT NOT NULL REFERENCES roles ON DELETE CASCADE,
+    PRIMARY KEY (user_id, role_id)
+);
+
+INSERT INTO roles (code)
+VALUES ('member'),
+       ('moderator'),
+       ('admin');
+
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id
+FROM roles
+         INNER JOIN permissions ON
+    CASE roles.code
+        WHEN 'member' THEN permissions.code IN ('questionnaire:read', 'questionnaire:write', 'answer:write')
+        WHEN 'moderator' THEN permissions.code IN ('questionnaire:read', 'questionnaire:write', 'answer:write',
+                                                   'questionnaire:moderate')
+        ELSE TRUE
+        END;
+
+INSERT INTO users_roles
+SELECT users.id, roles.id
+FROM users,
+     roles
+WHERE roles.code = 'member';
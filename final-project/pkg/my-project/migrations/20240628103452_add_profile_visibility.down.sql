@@ -0,0 +1,3 @@
+ALTER TABLE users
+    DROP COLUMN IF EXISTS surnameVisibility,
+    DROP COLUMN IF EXISTS emailVisibility;
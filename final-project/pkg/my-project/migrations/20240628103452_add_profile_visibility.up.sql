@@ -0,0 +1,7 @@
+-- Who can see a user's surname and email on their public profile: anyone, only signed in users,
+-- or only the user themselves.
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS surnameVisibility text NOT NULL DEFAULT 'public'
+        CHECK (surnameVisibility IN ('public', 'users', 'private')),
+    ADD COLUMN IF NOT EXISTS emailVisibility   text NOT NULL DEFAULT 'private'
+        CHECK (emailVisibility IN ('public', 'users', 'private'));
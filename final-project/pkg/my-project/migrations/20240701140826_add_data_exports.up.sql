@@ -0,0 +1,15 @@
+-- Archives of everything stored about a user, built in the background on request. A user has at
+-- most one export pending at a time; expired exports are deleted by the server within an hour.
+CREATE TABLE IF NOT EXISTS data_exports
+(
+    id          bigserial PRIMARY KEY,
+    userId      bigint                      NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    status      text                        NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'ready', 'failed')),
+    createdAt   timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    completedAt timestamp(0) with time zone,
+    expiry      timestamp(0) with time zone NOT NULL,
+    archive     bytea
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS data_exports_pending_idx ON data_exports (userId) WHERE status = 'pending';
@@ -0,0 +1,3 @@
+-- A revert token undoes exactly the change it was sent for, so the change records the hash of
+-- its token. Changes made before this can no longer be reverted.
+ALTER TABLE email_changes ADD COLUMN IF NOT EXISTS revertTokenHash bytea UNIQUE;
@@ -0,0 +1 @@
+ALTER TABLE answer DROP COLUMN IF EXISTS respondentHash;
@@ -0,0 +1,5 @@
+-- An anonymous answer keeps a hash of its receipt together with the id of its respondent. It
+-- can only be computed by someone holding the receipt, so it doesn't reveal the respondent, but
+-- lets the respondent's answer count be released when they delete the answer. Answers given
+-- before this have no hash, and deleting them doesn't release the count.
+ALTER TABLE answer ADD COLUMN IF NOT EXISTS respondentHash bytea;
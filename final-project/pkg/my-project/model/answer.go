@@ -2,20 +2,82 @@ package model
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"database/sql"
+	"encoding/base32"
+	"errors"
 	"fmt"
 	"github.com/Aminochka4/Golang/final-project/pkg/my-project/validator"
+	"github.com/lib/pq"
 	"log"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// Answer is a respondent's submission to a questionnaire. Answers to anonymous questionnaires
+// have no UserId; their respondent is handed a Receipt instead, which is only returned when the
+// answer is created and is needed to change or delete it later.
 type Answer struct {
-	Id              string `json:"id"`
-	CreatedAt       string `json:"createdAt"`
-	UpdatedAt       string `json:"updatedAt"`
-	QuestionnaireId string `json:"questionnaireId"`
-	Answer          string `json:"answer"`
-	UserId          int64  `json:"userId"`
+	Id              int64       `json:"id"`
+	CreatedAt       string      `json:"createdAt"`
+	UpdatedAt       string      `json:"updatedAt"`
+	QuestionnaireId int64       `json:"questionnaireId"`
+	Version         int         `json:"version"`
+	Responses       []*Response `json:"responses"`
+	UserId          int64       `json:"userId,omitempty"`
+	Receipt         string      `json:"receipt,omitempty"`
+	// EditableUntil is when the edit window of the questionnaire closes for this answer, if the
+	// questionnaire has one. It is only filled in by AnswerModel.Get.
+	EditableUntil *time.Time `json:"editableUntil,omitempty"`
+	receiptHash   []byte
+}
+
+var (
+	// ErrDuplicateResponse is returned when a user answers a single response questionnaire
+	// they have already answered.
+	ErrDuplicateResponse = errors.New("duplicate response")
+)
+
+// IsEditable reports whether the answer may still be changed or deleted at the given time.
+func (a *Answer) IsEditable(now time.Time) bool {
+	return a.EditableUntil == nil || now.Before(*a.EditableUntil)
+}
+
+// respondentHash ties the receipt of an anonymous answer to its respondent, without storing
+// anything that identifies the respondent to those who don't hold the receipt.
+func respondentHash(receipt string, userID int64) []byte {
+	hash := sha256.Sum256([]byte(receipt + ":" + strconv.FormatInt(userID, 10)))
+	return hash[:]
+}
+
+// MatchesReceipt reports whether the plaintext receipt was issued for this answer.
+func (a *Answer) MatchesReceipt(receipt string) bool {
+	if a.receiptHash == nil {
+		return false
+	}
+
+	hash := sha256.Sum256([]byte(receipt))
+	return subtle.ConstantTimeCompare(hash[:], a.receiptHash) == 1
+}
+
+// Redact hides the respondent of the answer from anyone but the respondent themselves and the
+// owner of the questionnaire.
+func (a *Answer) Redact(viewerID, ownerID int64) {
+	if viewerID == 0 || (viewerID != a.UserId && viewerID != ownerID) {
+		a.UserId = 0
+	}
+}
+
+// Response is the part of an answer that responds to a single question. Which of the value
+// fields is set depends on the type of the question.
+type Response struct {
+	QuestionId int64   `json:"questionId"`
+	OptionIds  []int64 `json:"optionIds,omitempty"`
+	Number     *int    `json:"number,omitempty"`
+	Text       *string `json:"text,omitempty"`
 }
 
 type AnswerModel struct {
@@ -24,14 +86,21 @@ type AnswerModel struct {
 	ErrorLog *log.Logger
 }
 
-func (a AnswerModel) GetAll() ([]*Answer, error) {
+// GetAll returns every answer. The respondent is only included in the answers the viewer gave
+// themselves or that respond to one of the viewer's questionnaires, see Answer.Redact.
+func (a AnswerModel) GetAll(viewerID int64) ([]*Answer, error) {
 	query := `
-		SELECT id, createdAt, updatedAt, questionnaireId, answer, userId
+		SELECT answer.id, answer.createdAt, answer.updatedAt, answer.questionnaireId, answer.version,
+			CASE WHEN answer.userId = $1 OR questionnaire.userId = $1 THEN COALESCE(answer.userId, 0) ELSE 0 END
 		FROM answer
-		ORDER BY id
+			INNER JOIN questionnaire ON questionnaire.id = answer.questionnaireId
+		ORDER BY answer.id
 	`
 
-	rows, err := a.DB.Query(query)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := a.DB.QueryContext(ctx, query, viewerID)
 	if err != nil {
 		return nil, err
 	}
@@ -40,7 +109,8 @@ func (a AnswerModel) GetAll() ([]*Answer, error) {
 	var answers []*Answer
 	for rows.Next() {
 		var answer Answer
-		err := rows.Scan(&answer.Id, &answer.CreatedAt, &answer.UpdatedAt, &answer.QuestionnaireId, &answer.Answer, &answer.UserId)
+		err := rows.Scan(&answer.Id, &answer.CreatedAt, &answer.UpdatedAt, &answer.QuestionnaireId, &answer.Version,
+			&answer.UserId)
 		if err != nil {
 			return nil, err
 		}
@@ -51,63 +121,217 @@ func (a AnswerModel) GetAll() ([]*Answer, error) {
 		return nil, err
 	}
 
+	err = loadResponses(ctx, a.DB, answers)
+	if err != nil {
+		return nil, err
+	}
+
+	return answers, nil
+}
+
+// GetAllForUser returns the answers a user gave, with their responses. Answers to anonymous
+// questionnaires aren't linked to their respondent, so they aren't included.
+func (a AnswerModel) GetAllForUser(userID int64) ([]*Answer, error) {
+	query := `
+		SELECT id, createdAt, updatedAt, questionnaireId, version, userId
+		FROM answer
+		WHERE userId = $1
+		ORDER BY id
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := a.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	answers := []*Answer{}
+	for rows.Next() {
+		var answer Answer
+		err := rows.Scan(&answer.Id, &answer.CreatedAt, &answer.UpdatedAt, &answer.QuestionnaireId, &answer.Version,
+			&answer.UserId)
+		if err != nil {
+			return nil, err
+		}
+		answers = append(answers, &answer)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = loadResponses(ctx, a.DB, answers)
+	if err != nil {
+		return nil, err
+	}
+
 	return answers, nil
 }
 
 func (a AnswerModel) Insert(answer *Answer) error {
-	// Insert a new menu item into the database.
+	// Insert the answer together with its responses in a single transaction. The answer is
+	// only inserted while its questionnaire is open, which is checked by the same statement so
+	// that a questionnaire closing at the same moment can't slip an answer through. For the
+	// same reason the statement decides whether the respondent is stored or receives a receipt,
+	// and records the version of the questionnaire that is answered.
 	query := `
-		INSERT INTO answer (questionnaireId, answer, userId) 
-		VALUES ($1, $2, $3) 
-		RETURNING id, createdAt, updatedAt
+		INSERT INTO answer (questionnaireId, version, userId, receiptHash, respondentHash)
+		SELECT questionnaire.id, questionnaire.version,
+			CASE WHEN questionnaire.anonymous THEN NULL ELSE $2::bigint END,
+			CASE WHEN questionnaire.anonymous THEN $3::bytea END,
+			CASE WHEN questionnaire.anonymous THEN $4::bytea END
+		FROM questionnaire
+		WHERE questionnaire.id = $1 AND ` + openCondition + `
+		RETURNING id, createdAt, updatedAt, version, COALESCE(userId, 0), receiptHash
 		`
-	args := []interface{}{answer.QuestionnaireId, answer.Answer, answer.UserId}
+
+	receipt, hash, err := generateReceipt()
+	if err != nil {
+		return err
+	}
+
+	// The respondent is counted even when the answer doesn't store them, so keep hold of them
+	// before answer.UserId is overwritten by what was actually stored.
+	respondentID := answer.UserId
+
+	args := []interface{}{answer.QuestionnaireId, respondentID, hash, respondentHash(receipt, respondentID)}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return a.DB.QueryRowContext(ctx, query, args...).Scan(&answer.Id, &answer.CreatedAt, &answer.UpdatedAt)
+	tx, err := a.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&answer.Id, &answer.CreatedAt, &answer.UpdatedAt,
+		&answer.Version, &answer.UserId, &answer.receiptHash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrQuestionnaireNotOpen
+		}
+		return err
+	}
+
+	if answer.receiptHash != nil {
+		answer.Receipt = receipt
+	}
+
+	// Count the answer against its respondent. For single response questionnaires the upsert
+	// leaves an existing, non-zero count alone and returns no row, which rejects the answer.
+	query = `
+		INSERT INTO questionnaire_respondent (questionnaireId, userId)
+		VALUES ($1, $2)
+		ON CONFLICT (questionnaireId, userId) DO UPDATE
+		SET responses = questionnaire_respondent.responses + 1
+		WHERE questionnaire_respondent.responses = 0 OR EXISTS (
+			SELECT 1 FROM questionnaire
+			WHERE questionnaire.id = EXCLUDED.questionnaireId AND questionnaire.responsePolicy = 'multiple'
+		)
+		RETURNING responses
+		`
+
+	var responses int
+	err = tx.QueryRowContext(ctx, query, answer.QuestionnaireId, respondentID).Scan(&responses)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrDuplicateResponse
+		}
+		return err
+	}
+
+	err = insertResponses(ctx, tx, answer)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func (a AnswerModel) Get(id int) (*Answer, error) {
-	// Retrieve a specific menu item based on its ID.
+	// Retrieve a specific answer based on its ID.
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 
 	query := `
-		SELECT id, createdAt, updatedAt, questionnaireId, answer, userId
+		SELECT answer.id, answer.createdAt, answer.updatedAt, answer.questionnaireId, answer.version,
+			COALESCE(answer.userId, 0), answer.receiptHash, answer.createdAt + make_interval(mins => questionnaire.editWindowMinutes)
 		FROM answer
-		WHERE id = $1
+			INNER JOIN questionnaire ON questionnaire.id = answer.questionnaireId
+		WHERE answer.id = $1
 		`
 	var answer Answer
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	row := a.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(&answer.Id, &answer.CreatedAt, &answer.UpdatedAt, &answer.QuestionnaireId, &answer.Answer, &answer.UserId)
+	err := row.Scan(&answer.Id, &answer.CreatedAt, &answer.UpdatedAt, &answer.QuestionnaireId, &answer.Version,
+		&answer.UserId, &answer.receiptHash, &answer.EditableUntil)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
 		return nil, fmt.Errorf("cannot retrive answer with id: %v, %w", id, err)
 	}
+
+	err = loadResponses(ctx, a.DB, []*Answer{&answer})
+	if err != nil {
+		return nil, err
+	}
+
 	return &answer, nil
 }
 
+// Update replaces the responses of an answer. The new responses are given against the current
+// version of the questionnaire, so the answer moves to that version.
 func (a AnswerModel) Update(answer *Answer) error {
-	// Update a specific menu item in the database.
 	query := `
 		UPDATE answer
-		SET questionnaireId = $1, answer = $2, userId = $3, updatedAt = CURRENT_TIMESTAMP
-		WHERE id = $4 AND updatedAt = $5
-		RETURNING updatedAt
+		SET updatedAt = CURRENT_TIMESTAMP,
+			version = (SELECT version FROM questionnaire WHERE questionnaire.id = answer.questionnaireId)
+		WHERE id = $1 AND updatedAt = $2
+		RETURNING updatedAt, version
 		`
-	args := []interface{}{answer.QuestionnaireId, answer.Answer, answer.UserId, answer.Id, answer.UpdatedAt}
+	args := []interface{}{answer.Id, answer.UpdatedAt}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return a.DB.QueryRowContext(ctx, query, args...).Scan(&answer.UpdatedAt)
+	tx, err := a.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&answer.UpdatedAt, &answer.Version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrEditConflict
+		}
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM answer_response WHERE answerId = $1`, answer.Id)
+	if err != nil {
+		return err
+	}
+
+	err = insertResponses(ctx, tx, answer)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func (a AnswerModel) Delete(id int) error {
-	// Delete a specific menu item from the database.
+// Delete removes an answer. The respondent's answer count goes down as well, so they may answer
+// a single response questionnaire again. An anonymous answer isn't linked to its respondent, so
+// its count is only released when the answer is deleted by its respondent: a user signed in as
+// userID who also sends the receipt. Deleting it with just the receipt leaves the count alone.
+func (a AnswerModel) Delete(id int, receipt string, userID int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
@@ -115,53 +339,311 @@ func (a AnswerModel) Delete(id int) error {
 	query := `
 		DELETE FROM answer
 		WHERE id = $1
+		RETURNING questionnaireId, userId, respondentHash
 		`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := a.DB.ExecContext(ctx, query, id)
-	return err
+	tx, err := a.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var questionnaireID int64
+	var respondentID sql.NullInt64
+	var hash []byte
+	err = tx.QueryRowContext(ctx, query, id).Scan(&questionnaireID, &respondentID, &hash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+
+	if !respondentID.Valid && hash != nil && receipt != "" && userID > 0 &&
+		subtle.ConstantTimeCompare(hash, respondentHash(receipt, userID)) == 1 {
+		respondentID = sql.NullInt64{Int64: userID, Valid: true}
+	}
+
+	if respondentID.Valid {
+		query = `
+			UPDATE questionnaire_respondent
+			SET responses = responses - 1
+			WHERE questionnaireId = $1 AND userId = $2 AND responses > 0
+			`
+		_, err = tx.ExecContext(ctx, query, questionnaireID, respondentID.Int64)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
+// GetByQuestionnaire returns every answer to a questionnaire together with its responses.
 func (a AnswerModel) GetByQuestionnaire(questionnaireID int) ([]*Answer, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	answers := []*Answer{}
+	err := a.ForEachByQuestionnaire(ctx, int64(questionnaireID), 0, func(answer *Answer) error {
+		answers = append(answers, answer)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return answers, nil
+}
+
+// ForEachByQuestionnaire calls fn for every answer to a questionnaire, in order of creation,
+// as the rows arrive from the database. Answers and their responses are read with a single
+// query, so a large questionnaire can be streamed without holding all of its answers in memory.
+// A non-zero version restricts the answers to those given against that version of the
+// questionnaire. Iteration stops at the first error returned by fn.
+func (a AnswerModel) ForEachByQuestionnaire(ctx context.Context, questionnaireID int64, version int, fn func(*Answer) error) error {
 	if questionnaireID < 1 {
-		return nil, ErrRecordNotFound
+		return ErrRecordNotFound
 	}
 
 	query := `
-        SELECT id, createdAt, updatedAt, questionnaireId, answer, userId
-        FROM answer
-        WHERE questionnaireId = $1
-    `
-
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+		SELECT answer.id, answer.createdAt, answer.updatedAt, answer.questionnaireId, answer.version,
+			COALESCE(answer.userId, 0), answer_response.questionId, answer_response.optionIds, answer_response.number, answer_response.text
+		FROM answer
+			LEFT JOIN answer_response ON answer_response.answerId = answer.id
+			LEFT JOIN question ON question.id = answer_response.questionId
+		WHERE answer.questionnaireId = $1 AND ($2 = 0 OR answer.version = $2)
+		ORDER BY answer.id, question.position
+		`
 
-	rows, err := a.DB.QueryContext(ctx, query, questionnaireID)
+	rows, err := a.DB.QueryContext(ctx, query, questionnaireID, version)
 	if err != nil {
-		return nil, fmt.Errorf("cannot retrieve answers for questionnaire with ID %d: %w", questionnaireID, err)
+		return fmt.Errorf("cannot retrieve answers for questionnaire with ID %d: %w", questionnaireID, err)
 	}
 	defer rows.Close()
 
-	var answers []*Answer
+	var current *Answer
 	for rows.Next() {
 		var answer Answer
-		if err := rows.Scan(&answer.Id, &answer.CreatedAt, &answer.UpdatedAt, &answer.QuestionnaireId, &answer.Answer, &answer.UserId); err != nil {
-			return nil, fmt.Errorf("cannot scan answer row: %w", err)
+		var questionID sql.NullInt64
+		var response Response
+
+		err := rows.Scan(&answer.Id, &answer.CreatedAt, &answer.UpdatedAt, &answer.QuestionnaireId, &answer.Version,
+			&answer.UserId, &questionID, (*pq.Int64Array)(&response.OptionIds), &response.Number, &response.Text)
+		if err != nil {
+			return fmt.Errorf("cannot scan answer row: %w", err)
+		}
+
+		// Rows are ordered by answer, so a new answer id means the previous answer is complete.
+		if current == nil || current.Id != answer.Id {
+			if current != nil {
+				if err := fn(current); err != nil {
+					return err
+				}
+			}
+			answer.Responses = []*Response{}
+			current = &answer
+		}
+
+		if questionID.Valid {
+			response.QuestionId = questionID.Int64
+			current.Responses = append(current.Responses, &response)
 		}
-		answers = append(answers, &answer)
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error reading answer rows: %w", err)
+		return fmt.Errorf("error reading answer rows: %w", err)
 	}
 
-	return answers, nil
+	if current != nil {
+		return fn(current)
+	}
+
+	return nil
+}
+
+// loadResponses fills in the Responses of every answer with a single query.
+func loadResponses(ctx context.Context, db querier, answers []*Answer) error {
+	if len(answers) == 0 {
+		return nil
+	}
+
+	byID := make(map[int64]*Answer, len(answers))
+	ids := make([]int64, 0, len(answers))
+	for _, answer := range answers {
+		answer.Responses = []*Response{}
+		byID[answer.Id] = answer
+		ids = append(ids, answer.Id)
+	}
+
+	query := `
+		SELECT answer_response.answerId, answer_response.questionId, answer_response.optionIds,
+			answer_response.number, answer_response.text
+		FROM answer_response
+			INNER JOIN question ON question.id = answer_response.questionId
+		WHERE answer_response.answerId = ANY($1)
+		ORDER BY answer_response.answerId, question.position
+		`
+
+	rows, err := db.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var response Response
+		var answerID int64
+		err := rows.Scan(&answerID, &response.QuestionId, (*pq.Int64Array)(&response.OptionIds),
+			&response.Number, &response.Text)
+		if err != nil {
+			return err
+		}
+		byID[answerID].Responses = append(byID[answerID].Responses, &response)
+	}
+
+	return rows.Err()
+}
+
+func insertResponses(ctx context.Context, db querier, answer *Answer) error {
+	query := `
+		INSERT INTO answer_response (answerId, questionId, optionIds, number, text)
+		VALUES ($1, $2, $3, $4, $5)
+		`
+
+	for _, response := range answer.Responses {
+		optionIDs := response.OptionIds
+		if optionIDs == nil {
+			optionIDs = []int64{}
+		}
+
+		args := []interface{}{answer.Id, response.QuestionId, pq.Array(optionIDs), response.Number, response.Text}
+
+		_, err := db.ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-func ValidateAnswer(v *validator.Validator, answer *Answer) {
-	// Check if the title field is empty.
-	v.Check(answer.Answer != "", "topic", "must be provided")
-	// Check if the description field is not more than 1000 characters.
-	v.Check(len(answer.Answer) <= 1000, "questions", "must not be more than 1000 bytes long")
+// generateReceipt returns a random plaintext receipt and its SHA-256 hash.
+func generateReceipt() (string, []byte, error) {
+	randomBytes := make([]byte, 16)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", nil, err
+	}
+
+	receipt := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	hash := sha256.Sum256([]byte(receipt))
+
+	return receipt, hash[:], nil
+}
+
+// ValidateAnswer checks that every response of an answer fits the question it responds to and
+// that every required question the respondent reached has a response. Questions hidden or
+// skipped by the questionnaire's logic must not be responded to.
+func ValidateAnswer(v *validator.Validator, answer *Answer, questions []*Question) {
+	v.Check(answer.QuestionnaireId > 0, "questionnaireId", "must be provided")
+	v.Check(len(answer.Responses) > 0, "responses", "must be provided")
+
+	byID := make(map[int64]*Question, len(questions))
+	for _, question := range questions {
+		byID[question.Id] = question
+	}
+
+	responses := make(map[int64]*Response, len(answer.Responses))
+	for _, response := range answer.Responses {
+		if _, ok := responses[response.QuestionId]; !ok {
+			responses[response.QuestionId] = response
+		}
+	}
+
+	reachable := reachableQuestions(questions, responses)
+
+	answered := make(map[int64]bool, len(answer.Responses))
+	for i, response := range answer.Responses {
+		prefix := fmt.Sprintf("responses[%d].", i)
+
+		question, ok := byID[response.QuestionId]
+		if !ok {
+			v.AddError(prefix+"questionId", "must reference a question of this questionnaire")
+			continue
+		}
+
+		if answered[response.QuestionId] {
+			v.AddError(prefix+"questionId", "must not respond to the same question twice")
+			continue
+		}
+		answered[response.QuestionId] = true
+
+		if !reachable[response.QuestionId] {
+			v.AddError(prefix+"questionId", "must not respond to a question that was skipped")
+			continue
+		}
+
+		validateResponse(v, prefix, response, question)
+	}
+
+	for _, question := range questions {
+		if question.Required && reachable[question.Id] && !answered[question.Id] {
+			v.AddError(fmt.Sprintf("questions[%d]", question.Position), "requires a response")
+		}
+	}
+}
+
+func validateResponse(v *validator.Validator, prefix string, response *Response, question *Question) {
+	if !question.IsChoice() {
+		v.Check(len(response.OptionIds) == 0, prefix+"optionIds", "must only be provided for choice questions")
+	}
+	if question.Type != QuestionScale {
+		v.Check(response.Number == nil, prefix+"number", "must only be provided for scale questions")
+	}
+	if question.Type != QuestionShortText && question.Type != QuestionLongText {
+		v.Check(response.Text == nil, prefix+"text", "must only be provided for text questions")
+	}
+
+	switch question.Type {
+	case QuestionSingleChoice, QuestionMultipleChoice:
+		if question.Type == QuestionSingleChoice {
+			v.Check(len(response.OptionIds) == 1, prefix+"optionIds", "must contain exactly one option")
+		} else {
+			v.Check(len(response.OptionIds) >= 1, prefix+"optionIds", "must contain at least one option")
+		}
+
+		valid := make(map[int64]bool, len(question.Options))
+		for _, option := range question.Options {
+			valid[option.Id] = true
+		}
+
+		seen := make(map[int64]bool, len(response.OptionIds))
+		for _, id := range response.OptionIds {
+			v.Check(valid[id], prefix+"optionIds", "must only contain options of this question")
+			v.Check(!seen[id], prefix+"optionIds", "must not contain duplicate values")
+			seen[id] = true
+		}
+
+	case QuestionShortText, QuestionLongText:
+		max := 500
+		if question.Type == QuestionLongText {
+			max = 10_000
+		}
+
+		v.Check(response.Text != nil && strings.TrimSpace(*response.Text) != "", prefix+"text", "must be provided")
+		if response.Text != nil {
+			v.Check(len(*response.Text) <= max, prefix+"text", fmt.Sprintf("must not be more than %d bytes long", max))
+		}
+
+	case QuestionScale:
+		v.Check(response.Number != nil, prefix+"number", "must be provided")
+		if response.Number != nil && question.ScaleMin != nil && question.ScaleMax != nil {
+			v.Check(*response.Number >= *question.ScaleMin && *response.Number <= *question.ScaleMax,
+				prefix+"number", fmt.Sprintf("must be between %d and %d", *question.ScaleMin, *question.ScaleMax))
+		}
+	}
 }
@@ -0,0 +1,196 @@
+package model
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Aminochka4/Golang/final-project/pkg/my-project/validator"
+	"github.com/lib/pq"
+)
+
+// apiKeyPrefix starts every API key, so that keys are easy to recognise, e.g. by secret scanners.
+const apiKeyPrefix = "qk_"
+
+// APIKey authenticates a service account or integration as the user who created it, but with
+// only the permissions listed on the key. Plaintext is only set right after the key is created.
+type APIKey struct {
+	Id          int64       `json:"id"`
+	UserId      int64       `json:"-"`
+	Name        string      `json:"name"`
+	Prefix      string      `json:"prefix"`
+	Plaintext   string      `json:"key,omitempty"`
+	Permissions Permissions `json:"permissions"`
+	CreatedAt   time.Time   `json:"createdAt"`
+	Expiry      *time.Time  `json:"expiry,omitempty"`
+	LastUsedAt  *time.Time  `json:"lastUsedAt,omitempty"`
+}
+
+type APIKeyModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Insert generates the secret of a new key and stores it.
+func (m APIKeyModel) Insert(key *APIKey) error {
+	randomBytes := make([]byte, 20)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return err
+	}
+
+	key.Plaintext = apiKeyPrefix + strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes))
+	key.Prefix = key.Plaintext[:len(apiKeyPrefix)+8]
+
+	query := `
+		INSERT INTO api_keys (userId, name, prefix, hash, permissions, expiry)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, createdAt
+		`
+
+	args := []interface{}{key.UserId, key.Name, key.Prefix, hashToken(key.Plaintext), pq.Array(key.Permissions), key.Expiry}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&key.Id, &key.CreatedAt)
+}
+
+// GetAllForUser returns the keys of a user, newest first, including expired ones.
+func (m APIKeyModel) GetAllForUser(userID int64) ([]*APIKey, error) {
+	query := `
+		SELECT id, userId, name, prefix, permissions, createdAt, expiry, lastUsedAt
+		FROM api_keys
+		WHERE userId = $1
+		ORDER BY id DESC
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		var key APIKey
+		err := rows.Scan(&key.Id, &key.UserId, &key.Name, &key.Prefix, pq.Array(&key.Permissions),
+			&key.CreatedAt, &key.Expiry, &key.LastUsedAt)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, &key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// GetForKey returns an unexpired key with the user it belongs to, and records that it was used.
+// Keys are used by integrations on every request, so the last use is only written once a minute.
+func (m APIKeyModel) GetForKey(plaintext string) (*APIKey, *User, error) {
+	query := `
+		WITH key AS (
+			SELECT id, userId, name, prefix, permissions, createdAt, expiry, lastUsedAt
+			FROM api_keys
+			WHERE hash = $1 AND (expiry IS NULL OR expiry > NOW())
+		), used AS (
+			UPDATE api_keys
+			SET lastUsedAt = NOW()
+			FROM key
+			WHERE api_keys.id = key.id
+				AND (api_keys.lastUsedAt IS NULL OR api_keys.lastUsedAt < NOW() - INTERVAL '1 minute')
+			RETURNING api_keys.id, api_keys.lastUsedAt
+		)
+		SELECT key.id, key.userId, key.name, key.prefix, key.permissions, key.createdAt, key.expiry,
+			COALESCE(used.lastUsedAt, key.lastUsedAt), users.id, users.createdAt, users.name,
+			users.surname, users.username, users.email, users.password, users.activated, users.version,
+			users.surnameVisibility, users.emailVisibility
+		FROM key
+			INNER JOIN users ON users.id = key.userId
+			LEFT JOIN used ON used.id = key.id
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var key APIKey
+	var user User
+	err := m.DB.QueryRowContext(ctx, query, hashToken(plaintext)).Scan(&key.Id, &key.UserId, &key.Name,
+		&key.Prefix, pq.Array(&key.Permissions), &key.CreatedAt, &key.Expiry, &key.LastUsedAt,
+		&user.Id, &user.CreatedAt, &user.Name, &user.Surname, &user.Username, &user.Email,
+		&user.Password.hash, &user.Activated, &user.Version,
+		&user.SurnameVisibility, &user.EmailVisibility)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	return &key, &user, nil
+}
+
+// Delete revokes one of a user's keys.
+func (m APIKeyModel) Delete(userID, id int64) error {
+	query := `
+		DELETE FROM api_keys
+		WHERE id = $1 AND userId = $2
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// IsAPIKey reports whether a credential looks like an API key rather than a token.
+func IsAPIKey(plaintext string) bool {
+	return strings.HasPrefix(plaintext, apiKeyPrefix)
+}
+
+// ValidateAPIKey checks a new key against the permissions its user holds.
+func ValidateAPIKey(v *validator.Validator, key *APIKey, granted Permissions) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 bytes long")
+
+	v.Check(len(key.Permissions) > 0, "permissions", "must contain at least one permission")
+	v.Check(validator.Unique(key.Permissions), "permissions", "must not contain duplicate values")
+	for _, code := range key.Permissions {
+		if !granted.Include(code) {
+			v.AddError("permissions", "must only contain permissions you hold, "+code+" is not one of them")
+			break
+		}
+	}
+
+	if key.Expiry != nil {
+		v.Check(key.Expiry.After(time.Now()), "expiry", "must be in the future")
+	}
+}
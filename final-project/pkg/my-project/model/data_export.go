@@ -0,0 +1,169 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+// Data export statuses. An export is pending while its archive is being built, and then either
+// ready to be downloaded or failed.
+const (
+	ExportPending = "pending"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+)
+
+var (
+	// ErrExportPending is returned when a user requests an export while another one of theirs
+	// is still being built.
+	ErrExportPending = errors.New("export pending")
+)
+
+// DataExport is a request of a user for an archive of everything stored about them.
+type DataExport struct {
+	Id          int64      `json:"id"`
+	UserId      int64      `json:"-"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+	Expiry      time.Time  `json:"expiry"`
+}
+
+type DataExportModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Insert starts a pending export that expires after ttl. Exports that have been pending for more
+// than an hour were abandoned, e.g. by a crash, and are marked as failed first.
+func (m DataExportModel) Insert(userID int64, ttl time.Duration) (*DataExport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE data_exports
+		SET status = 'failed', completedAt = NOW()
+		WHERE userId = $1 AND status = 'pending' AND createdAt < NOW() - INTERVAL '1 hour'
+		`
+
+	_, err = tx.ExecContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		INSERT INTO data_exports (userId, expiry)
+		VALUES ($1, $2)
+		RETURNING id, status, createdAt, expiry
+		`
+
+	export := &DataExport{UserId: userID}
+	err = tx.QueryRowContext(ctx, query, userID, time.Now().Add(ttl)).Scan(&export.Id, &export.Status,
+		&export.CreatedAt, &export.Expiry)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "data_exports_pending_idx"`:
+			return nil, ErrExportPending
+		default:
+			return nil, err
+		}
+	}
+
+	return export, tx.Commit()
+}
+
+// Get returns an unexpired export of a user, without its archive.
+func (m DataExportModel) Get(userID, id int64) (*DataExport, error) {
+	query := `
+		SELECT id, userId, status, createdAt, completedAt, expiry
+		FROM data_exports
+		WHERE id = $1 AND userId = $2 AND expiry > NOW()
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var export DataExport
+	err := m.DB.QueryRowContext(ctx, query, id, userID).Scan(&export.Id, &export.UserId, &export.Status,
+		&export.CreatedAt, &export.CompletedAt, &export.Expiry)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &export, nil
+}
+
+// GetArchive returns the archive of a ready export of a user.
+func (m DataExportModel) GetArchive(userID, id int64) ([]byte, error) {
+	query := `
+		SELECT archive
+		FROM data_exports
+		WHERE id = $1 AND userId = $2 AND status = 'ready' AND expiry > NOW()
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var archive []byte
+	err := m.DB.QueryRowContext(ctx, query, id, userID).Scan(&archive)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return archive, nil
+}
+
+// DeleteExpired deletes the exports that can no longer be downloaded, archives included, and
+// returns how many there were.
+func (m DataExportModel) DeleteExpired() (int64, error) {
+	query := `
+		DELETE FROM data_exports
+		WHERE expiry <= NOW()
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// Complete stores the archive of an export and makes it ready, or marks the export as failed if
+// archive is nil.
+func (m DataExportModel) Complete(id int64, archive []byte) error {
+	query := `
+		UPDATE data_exports
+		SET status = CASE WHEN $2::bytea IS NULL THEN 'failed' ELSE 'ready' END, archive = $2, completedAt = NOW()
+		WHERE id = $1 AND status = 'pending'
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id, archive)
+	return err
+}
@@ -0,0 +1,192 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+// EmailChange records a request to change the email address of a user. It is pending until the
+// new address is confirmed, and can be reverted from the old address afterwards.
+type EmailChange struct {
+	Id          int64      `json:"id"`
+	UserId      int64      `json:"userId"`
+	OldEmail    string     `json:"oldEmail"`
+	NewEmail    string     `json:"newEmail"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	ConfirmedAt *time.Time `json:"confirmedAt,omitempty"`
+	RevertedAt  *time.Time `json:"revertedAt,omitempty"`
+}
+
+type EmailChangeModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Request starts a change of a user's email address, which can be reverted with the revert
+// token. Any change still pending for the user is dropped, together with the confirmation token
+// sent for it.
+func (m EmailChangeModel) Request(userID int64, oldEmail, newEmail string, revert *Token) (*EmailChange, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM email_changes
+		WHERE userId = $1 AND confirmedAt IS NULL AND revertedAt IS NULL
+		`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE user_id = $1 AND scope = $2`, userID, ScopeEmailChange)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO email_changes (userId, oldEmail, newEmail, revertTokenHash)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, createdAt
+		`
+
+	change := &EmailChange{UserId: userID, OldEmail: oldEmail, NewEmail: newEmail}
+
+	err = tx.QueryRowContext(ctx, query, userID, oldEmail, newEmail, revert.Hash).Scan(&change.Id, &change.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return change, tx.Commit()
+}
+
+// Confirm applies the pending change of a user's email address. It returns ErrRecordNotFound if
+// there is none, and ErrDuplicateEmail if another user took the address in the meantime.
+func (m EmailChangeModel) Confirm(userID int64) (*EmailChange, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT id, userId, oldEmail, newEmail, createdAt
+		FROM email_changes
+		WHERE userId = $1 AND confirmedAt IS NULL AND revertedAt IS NULL
+		ORDER BY id DESC
+		LIMIT 1
+		FOR UPDATE
+		`
+
+	var change EmailChange
+	err = tx.QueryRowContext(ctx, query, userID).Scan(&change.Id, &change.UserId, &change.OldEmail,
+		&change.NewEmail, &change.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	err = setEmail(ctx, tx, userID, change.NewEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRowContext(ctx, `UPDATE email_changes SET confirmedAt = NOW() WHERE id = $1 RETURNING confirmedAt`,
+		change.Id).Scan(&change.ConfirmedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE user_id = $1 AND scope = $2`, userID, ScopeEmailChange)
+	if err != nil {
+		return nil, err
+	}
+
+	return &change, tx.Commit()
+}
+
+// Revert undoes the change of a user's email address that the revert token was sent for, by
+// putting back the address it replaced. Changes made after it are reverted along with it, since
+// they were most likely made by the same person, and every pending email change and revert token
+// of the user is invalidated. It returns ErrRecordNotFound if the change was already reverted.
+func (m EmailChangeModel) Revert(userID int64, revertTokenPlaintext string) (*EmailChange, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT id, userId, oldEmail, newEmail, createdAt, confirmedAt
+		FROM email_changes
+		WHERE userId = $1 AND revertTokenHash = $2 AND revertedAt IS NULL
+		FOR UPDATE
+		`
+
+	var change EmailChange
+	err = tx.QueryRowContext(ctx, query, userID, hashToken(revertTokenPlaintext)).Scan(&change.Id, &change.UserId,
+		&change.OldEmail, &change.NewEmail, &change.CreatedAt, &change.ConfirmedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	err = setEmail(ctx, tx, userID, change.OldEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRowContext(ctx, `
+		UPDATE email_changes
+		SET revertedAt = NOW()
+		WHERE userId = $1 AND id >= $2 AND revertedAt IS NULL
+		RETURNING revertedAt
+		`, userID, change.Id).Scan(&change.RevertedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE user_id = $1 AND scope IN ($2, $3)`,
+		userID, ScopeEmailChange, ScopeEmailRevert)
+	if err != nil {
+		return nil, err
+	}
+
+	return &change, tx.Commit()
+}
+
+func setEmail(ctx context.Context, db querier, userID int64, email string) error {
+	_, err := db.ExecContext(ctx, `UPDATE users SET email = $1, version = version + 1 WHERE id = $2`, email, userID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+			return ErrDuplicateEmail
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
@@ -21,7 +21,14 @@ func PopulateDatabase(models model.Models) error {
 //}
 
 var questionnaires = []model.Questionnaire{
-	{Topic: "Example", Questions: "Example", UserId: 1},
+	{
+		Topic:          "Example",
+		ResponsePolicy: model.ResponsePolicySingle,
+		Questions: []*model.Question{
+			{Type: model.QuestionShortText, Text: "Example"},
+		},
+		UserId: 1,
+	},
 	//{Title: "Greek Salad", Description: "Traditional Greek salad with feta cheese", NutritionValue: 200},
 	//{Title: "Caprese Salad", Description: "Fresh tomatoes and mozzarella slices", NutritionValue: 180},
 }
@@ -0,0 +1,115 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+// PendingLogin is a login that was sent to an OpenID Connect provider. It is kept by the hash of
+// its state until the provider redirects back.
+type PendingLogin struct {
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+}
+
+type IdentityModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// InsertLogin stores a login that is being sent to a provider. Logins that expired without
+// coming back are cleaned up along the way.
+func (m IdentityModel) InsertLogin(state string, login *PendingLogin, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM oidc_logins WHERE expiry <= NOW()`)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO oidc_logins (stateHash, provider, nonce, codeVerifier, expiry)
+		VALUES ($1, $2, $3, $4, $5)
+		`
+
+	args := []interface{}{hashToken(state), login.Provider, login.Nonce, login.CodeVerifier, time.Now().Add(ttl)}
+
+	_, err = m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// ConsumeLogin returns the login a provider redirected back with and deletes it, so that it can
+// only complete once.
+func (m IdentityModel) ConsumeLogin(state string) (*PendingLogin, error) {
+	query := `
+		DELETE FROM oidc_logins
+		WHERE stateHash = $1 AND expiry > NOW()
+		RETURNING provider, nonce, codeVerifier
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var login PendingLogin
+	err := m.DB.QueryRowContext(ctx, query, hashToken(state)).Scan(&login.Provider, &login.Nonce, &login.CodeVerifier)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &login, nil
+}
+
+// GetUser returns the user linked to an account at a provider.
+func (m IdentityModel) GetUser(provider, subject string) (*User, error) {
+	query := `
+		SELECT users.id, users.createdAt, users.name, users.surname, users.username,
+			users.email, users.password, users.activated, users.version, users.surnameVisibility,
+			users.emailVisibility
+		FROM users
+			INNER JOIN user_identities ON user_identities.userId = users.id
+		WHERE user_identities.provider = $1 AND user_identities.subject = $2
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var user User
+	err := m.DB.QueryRowContext(ctx, query, provider, subject).Scan(&user.Id, &user.CreatedAt, &user.Name,
+		&user.Surname, &user.Username, &user.Email, &user.Password.hash, &user.Activated, &user.Version,
+		&user.SurnameVisibility, &user.EmailVisibility)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+// Link records that an account at a provider belongs to a user.
+func (m IdentityModel) Link(userID int64, provider, subject, email string) error {
+	query := `
+		INSERT INTO user_identities (provider, subject, userId, email)
+		VALUES ($1, $2, $3, $4)
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, provider, subject, userID, email)
+	return err
+}
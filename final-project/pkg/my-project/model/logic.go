@@ -0,0 +1,453 @@
+package model
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+
+	"github.com/Aminochka4/Golang/final-project/pkg/my-project/validator"
+)
+
+// Operators of a Condition.
+const (
+	OperatorAnswered    = "answered"
+	OperatorEquals      = "equals"
+	OperatorNotEquals   = "not_equals"
+	OperatorLessThan    = "less_than"
+	OperatorGreaterThan = "greater_than"
+)
+
+// Logic holds the branching rules of a question. Other questions are referred to by position,
+// and a position one past the last question stands for the end of the questionnaire.
+type Logic struct {
+	// ShowIf hides the question unless the condition holds.
+	ShowIf *Condition `json:"showIf,omitempty"`
+	// Skip jumps over the questions that follow this one, up to the question of the first
+	// rule whose condition holds.
+	Skip []*SkipRule `json:"skip,omitempty"`
+}
+
+// Condition compares the response to the question at position Question. Choice questions are
+// compared by option text and scale questions by number; text questions only support answered.
+type Condition struct {
+	Question int    `json:"question"`
+	Operator string `json:"operator"`
+	Option   string `json:"option,omitempty"`
+	Number   *int   `json:"number,omitempty"`
+}
+
+type SkipRule struct {
+	If Condition `json:"if"`
+	To int       `json:"to"`
+}
+
+// LogicError is returned when a change to a single question leaves the branching logic of its
+// questionnaire invalid. Errors is keyed the same way as the errors of ValidateQuestionnaire.
+type LogicError struct {
+	Errors map[string]string
+}
+
+func (e *LogicError) Error() string {
+	return "invalid question logic"
+}
+
+// IsEmpty reports whether the logic has no rules at all.
+func (l *Logic) IsEmpty() bool {
+	return l == nil || (l.ShowIf == nil && len(l.Skip) == 0)
+}
+
+// Scan implements sql.Scanner so that a jsonb column can be read into a Logic.
+func (l *Logic) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case []byte:
+		return json.Unmarshal(src, l)
+	case string:
+		return json.Unmarshal([]byte(src), l)
+	default:
+		return fmt.Errorf("cannot scan %T into Logic", src)
+	}
+}
+
+// Value implements driver.Valuer so that a Logic can be written to a jsonb column. Empty logic
+// is stored as NULL.
+func (l *Logic) Value() (driver.Value, error) {
+	if l.IsEmpty() {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(data), nil
+}
+
+// renumber rewrites every position the logic refers to. position returns 0 for a question that
+// no longer exists, which validateLogic then reports.
+func (l *Logic) renumber(position func(int) int) {
+	if l == nil {
+		return
+	}
+
+	if l.ShowIf != nil {
+		l.ShowIf.Question = position(l.ShowIf.Question)
+	}
+	for _, rule := range l.Skip {
+		rule.If.Question = position(rule.If.Question)
+		rule.To = position(rule.To)
+	}
+}
+
+// furthestSkip returns the highest position the question's skip rules can jump to, or 0 when
+// it has none.
+func (l *Logic) furthestSkip() int {
+	furthest := 0
+	if l == nil {
+		return furthest
+	}
+
+	for _, rule := range l.Skip {
+		if rule.To > furthest {
+			furthest = rule.To
+		}
+	}
+	return furthest
+}
+
+// renumberLogic applies position to the logic of every question of the current version of a
+// questionnaire. It is used when questions are inserted, moved or deleted, so that conditions
+// keep pointing at the same questions.
+func renumberLogic(ctx context.Context, db querier, questionnaireID int64, position func(int) int) error {
+	query := `
+		SELECT id, logic
+		FROM question
+		WHERE questionnaireId = $1 AND logic IS NOT NULL AND ` + currentVersion + `
+		`
+
+	rows, err := db.QueryContext(ctx, query, questionnaireID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	logics := make(map[int64]*Logic)
+	for rows.Next() {
+		var id int64
+		var logic Logic
+		if err := rows.Scan(&id, &logic); err != nil {
+			return err
+		}
+		logics[id] = &logic
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	for id, logic := range logics {
+		logic.renumber(position)
+
+		_, err = db.ExecContext(ctx, `UPDATE question SET logic = $1 WHERE id = $2`, logic, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkLogic validates the logic of every question of a questionnaire as it is stored, so that
+// a change to one question can't leave the conditions of another one broken.
+func checkLogic(ctx context.Context, db querier, questionnaireID int64) error {
+	questions, err := getQuestions(ctx, db, questionnaireID)
+	if err != nil {
+		return err
+	}
+
+	v := validator.New()
+
+	if validateLogic(v, questions); !v.Valid() {
+		return &LogicError{Errors: v.Errors}
+	}
+
+	return nil
+}
+
+// validateLogic checks the logic of a questionnaire's questions, which are numbered in slice
+// order. Every condition must refer to an existing question and suit its type, skip rules may
+// only jump forward, and no question may depend on itself, directly or through other questions.
+func validateLogic(v *validator.Validator, questions []*Question) {
+	for i, question := range questions {
+		if question.Logic == nil {
+			continue
+		}
+
+		prefix := fmt.Sprintf("questions[%d].logic.", i)
+
+		if question.Logic.ShowIf != nil {
+			validateCondition(v, prefix+"showIf", question.Logic.ShowIf, questions)
+		}
+
+		v.Check(len(question.Logic.Skip) <= 20, prefix+"skip", "must not contain more than 20 rules")
+		for j, rule := range question.Logic.Skip {
+			key := fmt.Sprintf("%sskip[%d]", prefix, j)
+			validateCondition(v, key+".if", &rule.If, questions)
+			v.Check(rule.To > i+1 && rule.To <= len(questions)+1, key+".to", "must be a later question or the end of the questionnaire")
+		}
+	}
+
+	if i, ok := findCycle(logicDependencies(questions)); ok {
+		v.AddError(fmt.Sprintf("questions[%d].logic", i), "must not depend on itself through other questions")
+	}
+}
+
+func validateCondition(v *validator.Validator, key string, condition *Condition, questions []*Question) {
+	if condition.Question < 1 || condition.Question > len(questions) {
+		v.AddError(key+".question", "must reference a question of this questionnaire")
+		return
+	}
+
+	if !validator.In(condition.Operator, OperatorAnswered, OperatorEquals, OperatorNotEquals, OperatorLessThan, OperatorGreaterThan) {
+		v.AddError(key+".operator", "must be one of answered, equals, not_equals, less_than, greater_than")
+		return
+	}
+
+	target := questions[condition.Question-1]
+
+	switch {
+	case condition.Operator == OperatorAnswered:
+		v.Check(condition.Option == "" && condition.Number == nil, key, "must not compare a value when the operator is answered")
+
+	case target.IsChoice():
+		v.Check(validator.In(condition.Operator, OperatorEquals, OperatorNotEquals), key+".operator", "must be answered, equals or not_equals for choice questions")
+		v.Check(condition.Number == nil, key+".number", "must only be provided for scale questions")
+
+		found := false
+		for _, option := range target.Options {
+			if option.Text == condition.Option {
+				found = true
+				break
+			}
+		}
+		v.Check(found, key+".option", fmt.Sprintf("must be one of the options of question %d", condition.Question))
+
+	case target.Type == QuestionScale:
+		v.Check(condition.Option == "", key+".option", "must only be provided for choice questions")
+		v.Check(condition.Number != nil, key+".number", "must be provided")
+
+	default:
+		v.AddError(key+".operator", "must be answered for text questions")
+	}
+}
+
+// logicDependencies returns, for every question, the indexes of the questions that decide
+// whether it is reachable: the question its display condition refers to, and every earlier
+// question with skip rules that can jump over it, together with the questions those rules
+// refer to. References to questions that don't exist are left out.
+func logicDependencies(questions []*Question) [][]int {
+	deps := make([][]int, len(questions))
+
+	add := func(i, position int) {
+		if position >= 1 && position <= len(questions) {
+			deps[i] = append(deps[i], position-1)
+		}
+	}
+
+	for i, question := range questions {
+		if question.Logic == nil {
+			continue
+		}
+
+		if question.Logic.ShowIf != nil {
+			add(i, question.Logic.ShowIf.Question)
+		}
+
+		// The question at index j has position j+1, so it is skipped by a jump to any
+		// position after that.
+		furthest := question.Logic.furthestSkip()
+		for j := i + 1; j < len(questions) && j+1 < furthest; j++ {
+			add(j, i+1)
+			for _, rule := range question.Logic.Skip {
+				add(j, rule.If.Question)
+			}
+		}
+	}
+
+	return deps
+}
+
+// findCycle returns the index of a question that depends on itself, if there is one.
+func findCycle(deps [][]int) (int, bool) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := make([]int, len(deps))
+	cycle := -1
+
+	var visit func(i int) bool
+	visit = func(i int) bool {
+		switch state[i] {
+		case visiting:
+			cycle = i
+			return true
+		case visited:
+			return false
+		}
+
+		state[i] = visiting
+		for _, j := range deps[i] {
+			if visit(j) {
+				return true
+			}
+		}
+		state[i] = visited
+
+		return false
+	}
+
+	for i := range deps {
+		if visit(i) {
+			return cycle, true
+		}
+	}
+
+	return 0, false
+}
+
+// reachability works out which questions a respondent reached, given their responses. It
+// expects the questions in position order, as returned by getQuestions.
+type reachability struct {
+	questions []*Question
+	responses map[int64]*Response
+	state     []int
+}
+
+const (
+	reachUnknown = iota
+	reachPending
+	reachYes
+	reachNo
+)
+
+// reachableQuestions returns the ids of the questions the respondent reached. A question is
+// reachable unless its display condition doesn't hold or an earlier reachable question skipped
+// over it. Responses to unreachable questions are ignored when evaluating conditions.
+func reachableQuestions(questions []*Question, responses map[int64]*Response) map[int64]bool {
+	r := &reachability{
+		questions: questions,
+		responses: responses,
+		state:     make([]int, len(questions)),
+	}
+
+	reachable := make(map[int64]bool, len(questions))
+	for i, question := range questions {
+		reachable[question.Id] = r.reachable(i)
+	}
+	return reachable
+}
+
+func (r *reachability) reachable(i int) bool {
+	switch r.state[i] {
+	case reachYes:
+		return true
+	case reachNo, reachPending:
+		// A pending question means the logic has a cycle, which validation prevents from
+		// being saved; treat it as unreachable rather than recursing forever.
+		return false
+	}
+
+	r.state[i] = reachPending
+	ok := r.evaluate(i)
+	if ok {
+		r.state[i] = reachYes
+	} else {
+		r.state[i] = reachNo
+	}
+	return ok
+}
+
+func (r *reachability) evaluate(i int) bool {
+	question := r.questions[i]
+	if question.Logic != nil && question.Logic.ShowIf != nil && !r.holds(question.Logic.ShowIf) {
+		return false
+	}
+
+	for j := 0; j < i; j++ {
+		logic := r.questions[j].Logic
+		if logic.furthestSkip() <= i+1 || !r.reachable(j) {
+			continue
+		}
+
+		for _, rule := range logic.Skip {
+			if r.holds(&rule.If) {
+				if rule.To > i+1 {
+					return false
+				}
+				break
+			}
+		}
+	}
+
+	return true
+}
+
+func (r *reachability) holds(condition *Condition) bool {
+	i := condition.Question - 1
+	if i < 0 || i >= len(r.questions) || !r.reachable(i) {
+		return false
+	}
+
+	question := r.questions[i]
+	response, ok := r.responses[question.Id]
+	if !ok {
+		return false
+	}
+
+	if condition.Operator == OperatorAnswered {
+		return true
+	}
+
+	if question.IsChoice() {
+		selected := false
+		for _, option := range question.Options {
+			if option.Text != condition.Option {
+				continue
+			}
+			for _, id := range response.OptionIds {
+				if id == option.Id {
+					selected = true
+				}
+			}
+		}
+
+		switch condition.Operator {
+		case OperatorEquals:
+			return selected
+		case OperatorNotEquals:
+			return !selected
+		}
+		return false
+	}
+
+	if response.Number == nil || condition.Number == nil {
+		return false
+	}
+
+	switch condition.Operator {
+	case OperatorEquals:
+		return *response.Number == *condition.Number
+	case OperatorNotEquals:
+		return *response.Number != *condition.Number
+	case OperatorLessThan:
+		return *response.Number < *condition.Number
+	case OperatorGreaterThan:
+		return *response.Number > *condition.Number
+	}
+	return false
+}
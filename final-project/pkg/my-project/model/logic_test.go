@@ -0,0 +1,215 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/Aminochka4/Golang/final-project/pkg/my-project/validator"
+)
+
+func TestFindCycle(t *testing.T) {
+	tests := []struct {
+		name      string
+		deps      [][]int
+		wantCycle bool
+	}{
+		{name: "no questions", deps: [][]int{}},
+		{name: "chain", deps: [][]int{{}, {0}, {0, 1}}},
+		{name: "self dependency", deps: [][]int{{}, {1}}, wantCycle: true},
+		{name: "two questions", deps: [][]int{{1}, {0}}, wantCycle: true},
+		{name: "through a third question", deps: [][]int{{}, {2}, {3}, {1}}, wantCycle: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i, ok := findCycle(tt.deps)
+			if ok != tt.wantCycle {
+				t.Fatalf("got cycle %v, want %v", ok, tt.wantCycle)
+			}
+			if ok && !dependsOn(tt.deps, i, i) {
+				t.Errorf("got question %d, which doesn't depend on itself", i)
+			}
+		})
+	}
+}
+
+// dependsOn reports whether question from depends on question to, directly or indirectly.
+func dependsOn(deps [][]int, from, to int) bool {
+	seen := make([]bool, len(deps))
+	queue := append([]int(nil), deps[from]...)
+	for len(queue) > 0 {
+		i := queue[0]
+		queue = queue[1:]
+		if i == to {
+			return true
+		}
+		if !seen[i] {
+			seen[i] = true
+			queue = append(queue, deps[i]...)
+		}
+	}
+	return false
+}
+
+func TestValidateLogic(t *testing.T) {
+	choice := func(logic *Logic) *Question {
+		return &Question{
+			Type:    QuestionSingleChoice,
+			Options: []*Option{{Id: 1, Text: "yes"}, {Id: 2, Text: "no"}},
+			Logic:   logic,
+		}
+	}
+	text := func(logic *Logic) *Question {
+		return &Question{Type: QuestionShortText, Logic: logic}
+	}
+	answered := func(question int) *Condition {
+		return &Condition{Question: question, Operator: OperatorAnswered}
+	}
+
+	tests := []struct {
+		name      string
+		questions []*Question
+		wantKey   string
+	}{
+		{
+			name: "valid",
+			questions: []*Question{
+				choice(&Logic{Skip: []*SkipRule{{If: Condition{Question: 1, Operator: OperatorEquals, Option: "no"}, To: 3}}}),
+				text(nil),
+				text(&Logic{ShowIf: answered(2)}),
+			},
+		},
+		{
+			name:      "shown if it is answered itself",
+			questions: []*Question{text(nil), text(&Logic{ShowIf: answered(2)})},
+			wantKey:   "questions[1].logic",
+		},
+		{
+			name: "skip to itself",
+			questions: []*Question{
+				text(nil),
+				choice(&Logic{Skip: []*SkipRule{{If: *answered(2), To: 2}}}),
+				text(nil),
+			},
+			wantKey: "questions[1].logic.skip[0].to",
+		},
+		{
+			name: "skip to an earlier question",
+			questions: []*Question{
+				text(nil),
+				choice(&Logic{Skip: []*SkipRule{{If: *answered(2), To: 1}}}),
+			},
+			wantKey: "questions[1].logic.skip[0].to",
+		},
+		{
+			name: "display conditions on each other",
+			questions: []*Question{
+				text(&Logic{ShowIf: answered(2)}),
+				text(&Logic{ShowIf: answered(1)}),
+			},
+			wantKey: "questions[0].logic",
+		},
+		{
+			name: "skip decided by a question it skips",
+			questions: []*Question{
+				text(&Logic{Skip: []*SkipRule{{If: *answered(2), To: 3}}}),
+				text(nil),
+				text(nil),
+			},
+			wantKey: "questions[1].logic",
+		},
+		{
+			name:      "condition on a missing question",
+			questions: []*Question{text(&Logic{ShowIf: answered(5)})},
+			wantKey:   "questions[0].logic.showIf.question",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+			validateLogic(v, tt.questions)
+
+			if tt.wantKey == "" {
+				if !v.Valid() {
+					t.Errorf("got errors %v, want none", v.Errors)
+				}
+				return
+			}
+			if _, ok := v.Errors[tt.wantKey]; !ok {
+				t.Errorf("got errors %v, want one for %s", v.Errors, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestValidateAnswerReachability(t *testing.T) {
+	// Question 1 skips straight to question 3 when answered "no", and question 3 is only shown
+	// when question 1 was answered "yes".
+	questions := []*Question{
+		{
+			Id: 10, Position: 1, Type: QuestionSingleChoice, Required: true,
+			Options: []*Option{{Id: 1, Text: "yes"}, {Id: 2, Text: "no"}},
+			Logic: &Logic{Skip: []*SkipRule{
+				{If: Condition{Question: 1, Operator: OperatorEquals, Option: "no"}, To: 3},
+			}},
+		},
+		{Id: 20, Position: 2, Type: QuestionShortText, Required: true},
+		{
+			Id: 30, Position: 3, Type: QuestionShortText, Required: true,
+			Logic: &Logic{ShowIf: &Condition{Question: 1, Operator: OperatorEquals, Option: "yes"}},
+		},
+	}
+
+	text := "text"
+	yes := &Response{QuestionId: 10, OptionIds: []int64{1}}
+	no := &Response{QuestionId: 10, OptionIds: []int64{2}}
+
+	tests := []struct {
+		name      string
+		responses []*Response
+		wantKey   string
+	}{
+		{name: "all reached", responses: []*Response{yes, {QuestionId: 20, Text: &text}, {QuestionId: 30, Text: &text}}},
+		{name: "required question reached but not answered", responses: []*Response{yes, {QuestionId: 20, Text: &text}}, wantKey: "questions[3]"},
+		{name: "required questions skipped", responses: []*Response{no}},
+		{name: "response to a skipped question", responses: []*Response{no, {QuestionId: 20, Text: &text}}, wantKey: "responses[1].questionId"},
+		{name: "response to a hidden question", responses: []*Response{no, {QuestionId: 30, Text: &text}}, wantKey: "responses[1].questionId"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+			ValidateAnswer(v, &Answer{QuestionnaireId: 1, Responses: tt.responses}, questions)
+
+			if tt.wantKey == "" {
+				if !v.Valid() {
+					t.Errorf("got errors %v, want none", v.Errors)
+				}
+				return
+			}
+			if _, ok := v.Errors[tt.wantKey]; !ok {
+				t.Errorf("got errors %v, want one for %s", v.Errors, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestReachableQuestionsWithCycle(t *testing.T) {
+	// Validation keeps cycles out of the database; should one get there anyway, the questions
+	// involved are treated as unreachable instead of recursing forever.
+	questions := []*Question{
+		{Id: 10, Type: QuestionShortText, Logic: &Logic{ShowIf: &Condition{Question: 2, Operator: OperatorAnswered}}},
+		{Id: 20, Type: QuestionShortText, Logic: &Logic{ShowIf: &Condition{Question: 1, Operator: OperatorAnswered}}},
+		{Id: 30, Type: QuestionShortText},
+	}
+
+	text := "text"
+	reachable := reachableQuestions(questions, map[int64]*Response{
+		10: {QuestionId: 10, Text: &text},
+		20: {QuestionId: 20, Text: &text},
+	})
+
+	if reachable[10] || reachable[20] || !reachable[30] {
+		t.Errorf("got reachable %v, want only question 30", reachable)
+	}
+}
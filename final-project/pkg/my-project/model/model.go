@@ -18,9 +18,15 @@ var (
 type Models struct {
 	Users          UserModel
 	Questionnaires QuestionnaireModel
+	Questions      QuestionModel
 	Tokens         TokenModel
 	Permissions    PermissionModel
 	Answer         AnswerModel
+	EmailChanges   EmailChangeModel
+	Identities     IdentityModel
+	TOTP           TOTPModel
+	APIKeys        APIKeyModel
+	DataExports    DataExportModel
 }
 
 func NewModels(db *sql.DB) Models {
@@ -37,6 +43,11 @@ func NewModels(db *sql.DB) Models {
 			InfoLog:  infoLog,
 			ErrorLog: errorLog,
 		},
+		Questions: QuestionModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
 		Tokens: TokenModel{
 			DB:       db,
 			InfoLog:  infoLog,
@@ -52,5 +63,30 @@ func NewModels(db *sql.DB) Models {
 			InfoLog:  infoLog,
 			ErrorLog: errorLog,
 		},
+		EmailChanges: EmailChangeModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		Identities: IdentityModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		TOTP: TOTPModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		APIKeys: APIKeyModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
+		DataExports: DataExportModel{
+			DB:       db,
+			InfoLog:  infoLog,
+			ErrorLog: errorLog,
+		},
 	}
 }
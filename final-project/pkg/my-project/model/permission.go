@@ -9,6 +9,24 @@ import (
 	"github.com/lib/pq"
 )
 
+// The permission codes that routes require.
+const (
+	PermissionQuestionnaireRead     = "questionnaire:read"
+	PermissionQuestionnaireWrite    = "questionnaire:write"
+	PermissionQuestionnaireModerate = "questionnaire:moderate"
+	PermissionAnswerWrite           = "answer:write"
+	PermissionUsersAdmin            = "users:admin"
+)
+
+// The roles that group permissions. Every new user is a member; moderators can also delete and
+// close other users' questionnaires, and admins can do everything, including managing the roles
+// and permissions of users.
+const (
+	RoleMember    = "member"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
 type Permissions []string
 
 func (p Permissions) Include(code string) bool {
@@ -27,13 +45,20 @@ type PermissionModel struct {
 	ErrorLog *log.Logger
 }
 
+// GetAllForUser returns the permissions a user has, either directly or through their roles.
 func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 	query := `
 		SELECT permissions.code
 		FROM permissions
 			INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
-			INNER JOIN users ON users_permissions.user_id = users.id
-		WHERE users.id = $1
+		WHERE users_permissions.user_id = $1
+		UNION
+		SELECT permissions.code
+		FROM permissions
+			INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+			INNER JOIN users_roles ON users_roles.role_id = roles_permissions.role_id
+		WHERE users_roles.user_id = $1
+		ORDER BY code
 		`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -73,6 +98,7 @@ func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
 	query := `
 		INSERT INTO users_permissions
 		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+		ON CONFLICT DO NOTHING
 		`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -81,3 +107,173 @@ func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
 	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
 	return err
 }
+
+// GetDirectForUser returns the permissions granted to a user directly rather than by a role.
+func (m PermissionModel) GetDirectForUser(userID int64) (Permissions, error) {
+	query := `
+		SELECT permissions.code
+		FROM permissions
+			INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+		WHERE users_permissions.user_id = $1
+		ORDER BY permissions.code
+		`
+
+	return m.codes(query, userID)
+}
+
+// RemoveForUser revokes a permission granted to a user directly. Returns ErrRecordNotFound if the
+// user didn't have it.
+func (m PermissionModel) RemoveForUser(userID int64, code string) error {
+	query := `
+		DELETE FROM users_permissions
+		USING permissions
+		WHERE users_permissions.permission_id = permissions.id
+			AND users_permissions.user_id = $1 AND permissions.code = $2
+		`
+
+	return m.remove(query, userID, code)
+}
+
+// Exists reports whether a permission code is known.
+func (m PermissionModel) Exists(code string) (bool, error) {
+	query := `SELECT EXISTS(SELECT 1 FROM permissions WHERE code = $1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var exists bool
+	err := m.DB.QueryRowContext(ctx, query, code).Scan(&exists)
+	return exists, err
+}
+
+// Role is a named set of permissions.
+type Role struct {
+	Code        string      `json:"code"`
+	Permissions Permissions `json:"permissions"`
+}
+
+// GetRoles returns every role with its permissions.
+func (m PermissionModel) GetRoles() ([]*Role, error) {
+	query := `
+		SELECT roles.code, COALESCE(array_agg(permissions.code ORDER BY permissions.code)
+			FILTER (WHERE permissions.code IS NOT NULL), '{}')
+		FROM roles
+			LEFT JOIN roles_permissions ON roles_permissions.role_id = roles.id
+			LEFT JOIN permissions ON permissions.id = roles_permissions.permission_id
+		GROUP BY roles.id
+		ORDER BY roles.id
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []*Role{}
+	for rows.Next() {
+		var role Role
+		if err := rows.Scan(&role.Code, pq.Array(&role.Permissions)); err != nil {
+			return nil, err
+		}
+		roles = append(roles, &role)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// GetRolesForUser returns the codes of the roles a user has.
+func (m PermissionModel) GetRolesForUser(userID int64) ([]string, error) {
+	query := `
+		SELECT roles.code
+		FROM roles
+			INNER JOIN users_roles ON users_roles.role_id = roles.id
+		WHERE users_roles.user_id = $1
+		ORDER BY roles.id
+		`
+
+	return m.codes(query, userID)
+}
+
+// AddRolesForUser gives roles to a user. Roles the user already has are left as they are.
+func (m PermissionModel) AddRolesForUser(userID int64, codes ...string) error {
+	query := `
+		INSERT INTO users_roles
+		SELECT $1, roles.id FROM roles WHERE roles.code = ANY($2)
+		ON CONFLICT DO NOTHING
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	return err
+}
+
+// RemoveRoleForUser takes a role from a user. Returns ErrRecordNotFound if the user didn't have
+// it.
+func (m PermissionModel) RemoveRoleForUser(userID int64, code string) error {
+	query := `
+		DELETE FROM users_roles
+		USING roles
+		WHERE users_roles.role_id = roles.id AND users_roles.user_id = $1 AND roles.code = $2
+		`
+
+	return m.remove(query, userID, code)
+}
+
+// codes runs a query for a single column of codes.
+func (m PermissionModel) codes(query string, args ...interface{}) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	codes := []string{}
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// remove runs a delete query and returns ErrRecordNotFound if it deleted nothing.
+func (m PermissionModel) remove(query string, args ...interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
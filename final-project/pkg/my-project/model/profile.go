@@ -0,0 +1,89 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Aminochka4/Golang/final-project/pkg/my-project/validator"
+)
+
+// Who can see a field of a user's public profile.
+const (
+	VisibilityPublic  = "public"
+	VisibilityUsers   = "users"
+	VisibilityPrivate = "private"
+)
+
+// Profile is what other people see of a user. Surname and Email are only set if the user made
+// them visible to the viewer.
+type Profile struct {
+	Id             int64     `json:"id"`
+	CreatedAt      time.Time `json:"createdAt"`
+	Username       string    `json:"username"`
+	Name           string    `json:"name"`
+	Surname        string    `json:"surname,omitempty"`
+	Email          string    `json:"email,omitempty"`
+	Questionnaires int       `json:"questionnaires"`
+	Answers        int       `json:"answers"`
+}
+
+// GetProfile returns the public profile of a user as the viewer may see it. Questionnaires counts
+// the user's questionnaires that aren't drafts, and Answers the answers they gave to questionnaires
+// that aren't anonymous.
+func (u UserModel) GetProfile(id int64, viewer *User) (*Profile, error) {
+	query := `
+		SELECT users.id, users.createdAt, users.username, users.name, users.surname, users.email,
+			users.surnameVisibility, users.emailVisibility,
+			(SELECT COUNT(*) FROM questionnaire
+				WHERE questionnaire.userId = users.id AND questionnaire.status <> 'draft'),
+			(SELECT COUNT(*) FROM answer WHERE answer.userId = users.id)
+		FROM users
+		WHERE users.id = $1
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var profile Profile
+	var surnameVisibility, emailVisibility string
+	err := u.DB.QueryRowContext(ctx, query, id).Scan(&profile.Id, &profile.CreatedAt, &profile.Username,
+		&profile.Name, &profile.Surname, &profile.Email, &surnameVisibility, &emailVisibility,
+		&profile.Questionnaires, &profile.Answers)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if !visibleTo(surnameVisibility, profile.Id, viewer) {
+		profile.Surname = ""
+	}
+
+	if !visibleTo(emailVisibility, profile.Id, viewer) {
+		profile.Email = ""
+	}
+
+	return &profile, nil
+}
+
+// visibleTo reports whether a field of the user with the given id is visible to the viewer.
+func visibleTo(visibility string, userID int64, viewer *User) bool {
+	switch visibility {
+	case VisibilityPublic:
+		return true
+	case VisibilityUsers:
+		return !viewer.IsAnonymous()
+	default:
+		return !viewer.IsAnonymous() && viewer.Id == userID
+	}
+}
+
+func ValidateVisibility(v *validator.Validator, key, visibility string) {
+	v.Check(validator.In(visibility, VisibilityPublic, VisibilityUsers, VisibilityPrivate), key,
+		"must be one of public, users or private")
+}
@@ -0,0 +1,632 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Aminochka4/Golang/final-project/pkg/my-project/validator"
+	"github.com/lib/pq"
+)
+
+// Question types supported by a questionnaire.
+const (
+	QuestionSingleChoice   = "single_choice"
+	QuestionMultipleChoice = "multiple_choice"
+	QuestionShortText      = "short_text"
+	QuestionLongText       = "long_text"
+	QuestionScale          = "scale"
+)
+
+// QuestionTypes lists every valid value of Question.Type.
+var QuestionTypes = []string{
+	QuestionSingleChoice,
+	QuestionMultipleChoice,
+	QuestionShortText,
+	QuestionLongText,
+	QuestionScale,
+}
+
+type Question struct {
+	Id              int64     `json:"id"`
+	QuestionnaireId int64     `json:"questionnaireId"`
+	Position        int       `json:"position"`
+	Type            string    `json:"type"`
+	Text            string    `json:"text"`
+	Required        bool      `json:"required"`
+	ScaleMin        *int      `json:"scaleMin,omitempty"`
+	ScaleMax        *int      `json:"scaleMax,omitempty"`
+	Options         []*Option `json:"options,omitempty"`
+	Logic           *Logic    `json:"logic,omitempty"`
+}
+
+type Option struct {
+	Id       int64  `json:"id"`
+	Position int    `json:"position"`
+	Text     string `json:"text"`
+}
+
+// IsChoice reports whether the question is answered by selecting options.
+func (q *Question) IsChoice() bool {
+	return q.Type == QuestionSingleChoice || q.Type == QuestionMultipleChoice
+}
+
+type QuestionModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// currentVersion restricts a query on question to the questions of the current version of their
+// questionnaire. The questions of older versions are only kept for the answers given to them.
+const currentVersion = `question.version = (SELECT version FROM questionnaire WHERE questionnaire.id = question.questionnaireId)`
+
+// querier is satisfied by both *sql.DB and *sql.Tx, so the helpers below can run inside or
+// outside a transaction.
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func (m QuestionModel) GetAllForQuestionnaire(questionnaireID int64) ([]*Question, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return getQuestions(ctx, m.DB, questionnaireID)
+}
+
+func (m QuestionModel) Get(questionnaireID, id int64) (*Question, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, questionnaireId, position, type, text, required, scaleMin, scaleMax, logic
+		FROM question
+		WHERE id = $1 AND questionnaireId = $2 AND ` + currentVersion + `
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var question Question
+	err := m.DB.QueryRowContext(ctx, query, id, questionnaireID).Scan(&question.Id, &question.QuestionnaireId,
+		&question.Position, &question.Type, &question.Text, &question.Required, &question.ScaleMin, &question.ScaleMax,
+		&question.Logic)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	err = loadOptions(ctx, m.DB, []*Question{&question})
+	if err != nil {
+		return nil, err
+	}
+
+	return &question, nil
+}
+
+// Insert adds a question to its questionnaire at question.Position, shifting the questions at
+// and after that position down by one. A zero position appends the question to the end. The
+// logic of the new question refers to positions as they were before the insert, and is
+// renumbered along with the logic of the other questions.
+func (m QuestionModel) Insert(question *Question) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = forkVersion(ctx, tx, question.QuestionnaireId, true)
+	if err != nil {
+		return err
+	}
+
+	count, err := countQuestions(ctx, tx, question.QuestionnaireId)
+	if err != nil {
+		return err
+	}
+
+	if question.Position < 1 || question.Position > count {
+		question.Position = count + 1
+	}
+
+	query := `
+		UPDATE question
+		SET position = position + 1
+		WHERE questionnaireId = $1 AND position >= $2 AND ` + currentVersion + `
+		`
+
+	_, err = tx.ExecContext(ctx, query, question.QuestionnaireId, question.Position)
+	if err != nil {
+		return err
+	}
+
+	inserted := question.Position
+	position := func(old int) int {
+		if old >= inserted {
+			return old + 1
+		}
+		return old
+	}
+
+	err = renumberLogic(ctx, tx, question.QuestionnaireId, position)
+	if err != nil {
+		return err
+	}
+	question.Logic.renumber(position)
+
+	err = insertQuestion(ctx, tx, question)
+	if err != nil {
+		return err
+	}
+
+	err = checkLogic(ctx, tx, question.QuestionnaireId)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Update saves the question, moving it to question.Position if that changed. Options that carry
+// an id are updated in place, options without one are created and the remaining ones removed.
+// Like for Insert, the logic refers to positions as they were before the move.
+func (m QuestionModel) Update(question *Question) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	fork, err := forkVersion(ctx, tx, question.QuestionnaireId, true)
+	if err != nil {
+		return err
+	}
+	fork.apply(question)
+
+	var current int
+	query := `
+		SELECT position
+		FROM question
+		WHERE id = $1 AND questionnaireId = $2 AND ` + currentVersion + `
+		FOR UPDATE
+		`
+
+	err = tx.QueryRowContext(ctx, query, question.Id, question.QuestionnaireId).Scan(&current)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	query = `
+		UPDATE question
+		SET type = $1, text = $2, required = $3, scaleMin = $4, scaleMax = $5, logic = $6,
+			updatedAt = CURRENT_TIMESTAMP
+		WHERE id = $7 AND questionnaireId = $8
+		`
+
+	args := []interface{}{question.Type, question.Text, question.Required, question.ScaleMin,
+		question.ScaleMax, question.Logic, question.Id, question.QuestionnaireId}
+
+	_, err = tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	err = saveOptions(ctx, tx, question)
+	if err != nil {
+		return err
+	}
+
+	count, err := countQuestions(ctx, tx, question.QuestionnaireId)
+	if err != nil {
+		return err
+	}
+
+	if question.Position < 1 || question.Position > count {
+		question.Position = count
+	}
+
+	if question.Position != current {
+		err = moveQuestion(ctx, tx, question, current)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = checkLogic(ctx, tx, question.QuestionnaireId)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// moveQuestion moves a question from position current to question.Position, shifting the
+// questions in between and renumbering the logic that refers to any of them.
+func moveQuestion(ctx context.Context, db querier, question *Question, current int) error {
+	target := question.Position
+
+	query := `
+		UPDATE question
+		SET position = position + 1
+		WHERE questionnaireId = $1 AND position >= $2 AND position < $3 AND ` + currentVersion + `
+		`
+	args := []interface{}{question.QuestionnaireId, target, current}
+
+	if target > current {
+		query = `
+			UPDATE question
+			SET position = position - 1
+			WHERE questionnaireId = $1 AND position > $2 AND position <= $3 AND ` + currentVersion + `
+			`
+		args = []interface{}{question.QuestionnaireId, current, target}
+	}
+
+	_, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, `UPDATE question SET position = $1 WHERE id = $2`, target, question.Id)
+	if err != nil {
+		return err
+	}
+
+	position := func(old int) int {
+		switch {
+		case old == current:
+			return target
+		case target < current && old >= target && old < current:
+			return old + 1
+		case target > current && old > current && old <= target:
+			return old - 1
+		}
+		return old
+	}
+
+	err = renumberLogic(ctx, db, question.QuestionnaireId, position)
+	if err != nil {
+		return err
+	}
+	question.Logic.renumber(position)
+
+	return nil
+}
+
+// Delete removes a question and closes the gap it leaves in the questionnaire's ordering.
+func (m QuestionModel) Delete(questionnaireID, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	fork, err := forkVersion(ctx, tx, questionnaireID, true)
+	if err != nil {
+		return err
+	}
+	id = fork.questionID(id)
+
+	var position int
+	query := `
+		DELETE FROM question
+		WHERE id = $1 AND questionnaireId = $2 AND ` + currentVersion + `
+		RETURNING position
+		`
+
+	err = tx.QueryRowContext(ctx, query, id, questionnaireID).Scan(&position)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	query = `
+		UPDATE question
+		SET position = position - 1
+		WHERE questionnaireId = $1 AND position > $2 AND ` + currentVersion + `
+		`
+
+	_, err = tx.ExecContext(ctx, query, questionnaireID, position)
+	if err != nil {
+		return err
+	}
+
+	// Conditions on the deleted question become dangling, which checkLogic reports so that the
+	// owner has to remove them first.
+	err = renumberLogic(ctx, tx, questionnaireID, func(old int) int {
+		switch {
+		case old == position:
+			return 0
+		case old > position:
+			return old - 1
+		}
+		return old
+	})
+	if err != nil {
+		return err
+	}
+
+	err = checkLogic(ctx, tx, questionnaireID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// getQuestions returns the questions of the current version of a questionnaire, ordered by
+// position, together with their options.
+func getQuestions(ctx context.Context, db querier, questionnaireID int64) ([]*Question, error) {
+	query := `
+		SELECT id, questionnaireId, position, type, text, required, scaleMin, scaleMax, logic
+		FROM question
+		WHERE questionnaireId = $1 AND ` + currentVersion + `
+		ORDER BY position
+		`
+
+	return queryQuestions(ctx, db, query, questionnaireID)
+}
+
+// getVersionQuestions returns the questions of the given version of a questionnaire.
+func getVersionQuestions(ctx context.Context, db querier, questionnaireID int64, version int) ([]*Question, error) {
+	query := `
+		SELECT id, questionnaireId, position, type, text, required, scaleMin, scaleMax, logic
+		FROM question
+		WHERE questionnaireId = $1 AND version = $2
+		ORDER BY position
+		`
+
+	return queryQuestions(ctx, db, query, questionnaireID, version)
+}
+
+func queryQuestions(ctx context.Context, db querier, query string, args ...interface{}) ([]*Question, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	questions := []*Question{}
+	for rows.Next() {
+		var question Question
+		err := rows.Scan(&question.Id, &question.QuestionnaireId, &question.Position, &question.Type,
+			&question.Text, &question.Required, &question.ScaleMin, &question.ScaleMax, &question.Logic)
+		if err != nil {
+			return nil, err
+		}
+		questions = append(questions, &question)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = loadOptions(ctx, db, questions)
+	if err != nil {
+		return nil, err
+	}
+
+	return questions, nil
+}
+
+// loadOptions fills in the Options of every question with a single query.
+func loadOptions(ctx context.Context, db querier, questions []*Question) error {
+	if len(questions) == 0 {
+		return nil
+	}
+
+	byID := make(map[int64]*Question, len(questions))
+	ids := make([]int64, 0, len(questions))
+	for _, question := range questions {
+		byID[question.Id] = question
+		ids = append(ids, question.Id)
+	}
+
+	query := `
+		SELECT id, questionId, position, text
+		FROM question_option
+		WHERE questionId = ANY($1)
+		ORDER BY questionId, position
+		`
+
+	rows, err := db.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var option Option
+		var questionID int64
+		if err := rows.Scan(&option.Id, &questionID, &option.Position, &option.Text); err != nil {
+			return err
+		}
+		byID[questionID].Options = append(byID[questionID].Options, &option)
+	}
+
+	return rows.Err()
+}
+
+func countQuestions(ctx context.Context, db querier, questionnaireID int64) (int, error) {
+	var count int
+	query := `SELECT count(*) FROM question WHERE questionnaireId = $1 AND ` + currentVersion
+
+	err := db.QueryRowContext(ctx, query, questionnaireID).Scan(&count)
+	return count, err
+}
+
+// insertQuestion inserts a question and its options into the current version of its
+// questionnaire. The caller is responsible for making sure question.Position is free.
+func insertQuestion(ctx context.Context, db querier, question *Question) error {
+	query := `
+		INSERT INTO question (questionnaireId, version, position, type, text, required, scaleMin, scaleMax, logic)
+		VALUES ($1, (SELECT version FROM questionnaire WHERE id = $1), $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id
+		`
+
+	args := []interface{}{question.QuestionnaireId, question.Position, question.Type, question.Text,
+		question.Required, question.ScaleMin, question.ScaleMax, question.Logic}
+
+	err := db.QueryRowContext(ctx, query, args...).Scan(&question.Id)
+	if err != nil {
+		return err
+	}
+
+	for _, option := range question.Options {
+		option.Id = 0
+	}
+
+	return saveOptions(ctx, db, question)
+}
+
+// replaceQuestions deletes every question of the current version of a questionnaire and inserts
+// the given ones in their place, numbering them in slice order.
+func replaceQuestions(ctx context.Context, db querier, questionnaireID int64, questions []*Question) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM question WHERE questionnaireId = $1 AND `+currentVersion, questionnaireID)
+	if err != nil {
+		return err
+	}
+
+	for i, question := range questions {
+		question.QuestionnaireId = questionnaireID
+		question.Position = i + 1
+
+		err = insertQuestion(ctx, db, question)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// saveOptions synchronises the question_option rows of a question with question.Options and
+// renumbers them in slice order.
+func saveOptions(ctx context.Context, db querier, question *Question) error {
+	keep := []int64{}
+	for _, option := range question.Options {
+		if option.Id != 0 {
+			keep = append(keep, option.Id)
+		}
+	}
+
+	query := `
+		DELETE FROM question_option
+		WHERE questionId = $1 AND NOT (id = ANY($2))
+		`
+
+	_, err := db.ExecContext(ctx, query, question.Id, pq.Array(keep))
+	if err != nil {
+		return err
+	}
+
+	for i, option := range question.Options {
+		option.Position = i + 1
+
+		if option.Id == 0 {
+			query = `
+				INSERT INTO question_option (questionId, position, text)
+				VALUES ($1, $2, $3)
+				RETURNING id
+				`
+			err = db.QueryRowContext(ctx, query, question.Id, option.Position, option.Text).Scan(&option.Id)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		query = `
+			UPDATE question_option
+			SET position = $1, text = $2
+			WHERE id = $3 AND questionId = $4
+			`
+		result, err := db.ExecContext(ctx, query, option.Position, option.Text, option.Id, question.Id)
+		if err != nil {
+			return err
+		}
+
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if affected == 0 {
+			return fmt.Errorf("option %d does not belong to question %d: %w", option.Id, question.Id, ErrRecordNotFound)
+		}
+	}
+
+	return nil
+}
+
+// ValidateQuestion runs validation checks on a single question and its options.
+func ValidateQuestion(v *validator.Validator, question *Question) {
+	validateQuestion(v, "", question)
+}
+
+// validateQuestion checks a question, prefixing every error key so that questions nested in a
+// questionnaire can be told apart.
+func validateQuestion(v *validator.Validator, prefix string, question *Question) {
+	v.Check(question.Text != "", prefix+"text", "must be provided")
+	v.Check(len(question.Text) <= 1000, prefix+"text", "must not be more than 1000 bytes long")
+	v.Check(validator.In(question.Type, QuestionTypes...), prefix+"type", "must be one of single_choice, multiple_choice, short_text, long_text, scale")
+
+	if question.IsChoice() {
+		v.Check(len(question.Options) >= 2, prefix+"options", "must contain at least 2 options")
+		v.Check(len(question.Options) <= 50, prefix+"options", "must not contain more than 50 options")
+
+		texts := make([]string, 0, len(question.Options))
+		for i, option := range question.Options {
+			key := fmt.Sprintf("%soptions[%d].text", prefix, i)
+			v.Check(option.Text != "", key, "must be provided")
+			v.Check(len(option.Text) <= 500, key, "must not be more than 500 bytes long")
+			texts = append(texts, option.Text)
+		}
+		v.Check(validator.Unique(texts), prefix+"options", "must not contain duplicate values")
+	} else {
+		v.Check(len(question.Options) == 0, prefix+"options", "must only be provided for choice questions")
+	}
+
+	if question.Type == QuestionScale {
+		v.Check(question.ScaleMin != nil, prefix+"scaleMin", "must be provided")
+		v.Check(question.ScaleMax != nil, prefix+"scaleMax", "must be provided")
+
+		if question.ScaleMin != nil && question.ScaleMax != nil {
+			v.Check(*question.ScaleMin < *question.ScaleMax, prefix+"scaleMax", "must be greater than scaleMin")
+			v.Check(*question.ScaleMax-*question.ScaleMin <= 100, prefix+"scaleMax", "must not be more than 100 steps above scaleMin")
+		}
+	} else {
+		v.Check(question.ScaleMin == nil && question.ScaleMax == nil, prefix+"scaleMin", "must only be provided for scale questions")
+	}
+}
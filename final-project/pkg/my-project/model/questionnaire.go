@@ -3,19 +3,84 @@ package model
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/Aminochka4/Golang/final-project/pkg/my-project/validator"
 	"log"
 	"time"
 )
 
+// Questionnaire statuses. A draft is only visible to its owner, a published questionnaire accepts
+// answers between its OpensAt and ClosesAt times and a closed one no longer accepts any.
+const (
+	StatusDraft     = "draft"
+	StatusPublished = "published"
+	StatusClosed    = "closed"
+)
+
+// Response policies. A single response questionnaire accepts one answer per user, a multiple
+// response one any number of them.
+const (
+	ResponsePolicySingle   = "single"
+	ResponsePolicyMultiple = "multiple"
+)
+
+var (
+	// ErrQuestionnaireNotOpen is returned when answering a questionnaire that doesn't accept
+	// answers at the moment.
+	ErrQuestionnaireNotOpen = errors.New("questionnaire is not open")
+)
+
 type Questionnaire struct {
-	Id        string `json:"id"`
-	CreatedAt string `json:"createdAt"`
-	UpdatedAt string `json:"updatedAt"`
-	Topic     string `json:"topic"`
-	Questions string `json:"questions"`
-	UserId    int64  `json:"userId"`
+	Id        int64      `json:"id"`
+	CreatedAt string     `json:"createdAt"`
+	UpdatedAt string     `json:"updatedAt"`
+	Topic     string     `json:"topic"`
+	Version   int        `json:"version"`
+	Status    string     `json:"status"`
+	OpensAt   *time.Time `json:"opensAt,omitempty"`
+	ClosesAt  *time.Time `json:"closesAt,omitempty"`
+	Anonymous bool       `json:"anonymous"`
+	// IsTemplate lists the questionnaire in the template gallery, from which anyone can
+	// duplicate it.
+	IsTemplate bool `json:"isTemplate"`
+	// ResponsePolicy is one of the ResponsePolicy constants. EditWindowMinutes, when set, is
+	// how long after submitting an answer its respondent may still change or delete it.
+	ResponsePolicy    string      `json:"responsePolicy"`
+	EditWindowMinutes *int        `json:"editWindowMinutes,omitempty"`
+	Questions         []*Question `json:"questions,omitempty"`
+	UserId            int64       `json:"userId"`
+}
+
+// openCondition is the SQL counterpart of Questionnaire.IsOpen.
+const openCondition = `questionnaire.status = 'published'
+	AND (questionnaire.opensAt IS NULL OR questionnaire.opensAt <= NOW())
+	AND (questionnaire.closesAt IS NULL OR questionnaire.closesAt > NOW())`
+
+// IsOpen reports whether the questionnaire accepts answers at the given time.
+func (q *Questionnaire) IsOpen(now time.Time) bool {
+	if q.Status != StatusPublished {
+		return false
+	}
+	if q.OpensAt != nil && now.Before(*q.OpensAt) {
+		return false
+	}
+	if q.ClosesAt != nil && !now.Before(*q.ClosesAt) {
+		return false
+	}
+	return true
+}
+
+// IsClosed reports whether the questionnaire stopped accepting answers, either because it was
+// closed or because its closing time has passed.
+func (q *Questionnaire) IsClosed(now time.Time) bool {
+	return q.Status == StatusClosed || (q.Status == StatusPublished && q.ClosesAt != nil && !now.Before(*q.ClosesAt))
+}
+
+// VisibleTo reports whether the user with the given id may see the questionnaire. Drafts are
+// only visible to their owner, unless they are templates.
+func (q *Questionnaire) VisibleTo(userID int64) bool {
+	return q.Status != StatusDraft || q.IsTemplate || q.UserId == userID
 }
 
 type QuestionnaireModel struct {
@@ -24,12 +89,18 @@ type QuestionnaireModel struct {
 	ErrorLog *log.Logger
 }
 
-func (q QuestionnaireModel) GetAll(topic string, filters Filters) ([]*Questionnaire, error) {
+// GetAll returns the questionnaires the viewer may see, that is everything but other users'
+// drafts that aren't templates. Templates are kept apart from the other questionnaires: they are
+// only returned, and the only ones returned, when templates is true.
+func (q QuestionnaireModel) GetAll(topic string, templates bool, viewerID int64, filters Filters) ([]*Questionnaire, error) {
 	// Формируем базовый запрос SQL
 	query := `
-		SELECT id, createdAt, updatedAt, topic, questions, userId
+		SELECT id, createdAt, updatedAt, topic, version, status, opensAt, closesAt, anonymous, isTemplate,
+			responsePolicy, editWindowMinutes, userId
 		FROM questionnaire
 		WHERE ($1 = '' OR LOWER(topic) = LOWER($1))
+			AND isTemplate = $5
+			AND (status <> 'draft' OR isTemplate OR userId = $4)
 	`
 
 	// Добавляем сортировку в запрос, если указано значение Sort
@@ -40,7 +111,7 @@ func (q QuestionnaireModel) GetAll(topic string, filters Filters) ([]*Questionna
 	// Добавляем параметры пагинации в запрос
 	query += " LIMIT $2 OFFSET $3"
 
-	rows, err := q.DB.Query(query, topic, filters.PageSize, (filters.Page-1)*filters.PageSize)
+	rows, err := q.DB.Query(query, topic, filters.PageSize, (filters.Page-1)*filters.PageSize, viewerID, templates)
 	if err != nil {
 		return nil, err
 	}
@@ -49,7 +120,9 @@ func (q QuestionnaireModel) GetAll(topic string, filters Filters) ([]*Questionna
 	var questionnaires []*Questionnaire
 	for rows.Next() {
 		var questionnaire Questionnaire
-		err := rows.Scan(&questionnaire.Id, &questionnaire.CreatedAt, &questionnaire.UpdatedAt, &questionnaire.Topic, &questionnaire.Questions, &questionnaire.UserId)
+		err := rows.Scan(&questionnaire.Id, &questionnaire.CreatedAt, &questionnaire.UpdatedAt, &questionnaire.Topic,
+			&questionnaire.Version, &questionnaire.Status, &questionnaire.OpensAt, &questionnaire.ClosesAt,
+			&questionnaire.Anonymous, &questionnaire.IsTemplate, &questionnaire.ResponsePolicy, &questionnaire.EditWindowMinutes, &questionnaire.UserId)
 		if err != nil {
 			return nil, err
 		}
@@ -63,18 +136,96 @@ func (q QuestionnaireModel) GetAll(topic string, filters Filters) ([]*Questionna
 	return questionnaires, nil
 }
 
+// GetAllForUser returns every questionnaire a user owns, including drafts and templates, with
+// the questions of their current versions.
+func (q QuestionnaireModel) GetAllForUser(userID int64) ([]*Questionnaire, error) {
+	query := `
+		SELECT id, createdAt, updatedAt, topic, version, status, opensAt, closesAt, anonymous, isTemplate,
+			responsePolicy, editWindowMinutes, userId
+		FROM questionnaire
+		WHERE userId = $1
+		ORDER BY id
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := q.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	questionnaires := []*Questionnaire{}
+	for rows.Next() {
+		var questionnaire Questionnaire
+		err := rows.Scan(&questionnaire.Id, &questionnaire.CreatedAt, &questionnaire.UpdatedAt, &questionnaire.Topic,
+			&questionnaire.Version, &questionnaire.Status, &questionnaire.OpensAt, &questionnaire.ClosesAt,
+			&questionnaire.Anonymous, &questionnaire.IsTemplate, &questionnaire.ResponsePolicy, &questionnaire.EditWindowMinutes, &questionnaire.UserId)
+		if err != nil {
+			return nil, err
+		}
+		questionnaires = append(questionnaires, &questionnaire)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, questionnaire := range questionnaires {
+		questionnaire.Questions, err = getQuestions(ctx, q.DB, questionnaire.Id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return questionnaires, nil
+}
+
 func (q QuestionnaireModel) Insert(questionnaire *Questionnaire) error {
-	// Insert a new menu item into the database.
+	// Insert the questionnaire together with its questions in a single transaction.
 	query := `
-		INSERT INTO questionnaire (topic, questions, userId) 
-		VALUES ($1, $2, $3) 
-		RETURNING id, createdAt, updatedAt
+		INSERT INTO questionnaire (topic, status, opensAt, closesAt, anonymous, isTemplate, responsePolicy, editWindowMinutes, userId) 
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) 
+		RETURNING id, createdAt, updatedAt, version
 		`
-	args := []interface{}{questionnaire.Topic, questionnaire.Questions, questionnaire.UserId}
+	if questionnaire.Status == "" {
+		questionnaire.Status = StatusDraft
+	}
+	args := []interface{}{questionnaire.Topic, questionnaire.Status, questionnaire.OpensAt, questionnaire.ClosesAt,
+		questionnaire.Anonymous, questionnaire.IsTemplate, questionnaire.ResponsePolicy, questionnaire.EditWindowMinutes,
+		questionnaire.UserId}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return q.DB.QueryRowContext(ctx, query, args...).Scan(&questionnaire.Id, &questionnaire.CreatedAt, &questionnaire.UpdatedAt)
+	tx, err := q.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&questionnaire.Id, &questionnaire.CreatedAt, &questionnaire.UpdatedAt,
+		&questionnaire.Version)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO questionnaire_version (questionnaireId, version, topic)
+		VALUES ($1, $2, $3)
+		`
+
+	_, err = tx.ExecContext(ctx, query, questionnaire.Id, questionnaire.Version, questionnaire.Topic)
+	if err != nil {
+		return err
+	}
+
+	err = replaceQuestions(ctx, tx, questionnaire.Id, questionnaire.Questions)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func (q QuestionnaireModel) Get(id int) (*Questionnaire, error) {
@@ -84,7 +235,8 @@ func (q QuestionnaireModel) Get(id int) (*Questionnaire, error) {
 	}
 
 	query := `
-		SELECT id, createdAt, updatedAt, topic, questions, userId
+		SELECT id, createdAt, updatedAt, topic, version, status, opensAt, closesAt, anonymous, isTemplate,
+			responsePolicy, editWindowMinutes, userId
 		FROM questionnaire
 		WHERE id = $1
 		`
@@ -93,26 +245,85 @@ func (q QuestionnaireModel) Get(id int) (*Questionnaire, error) {
 	defer cancel()
 
 	row := q.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(&questionnaire.Id, &questionnaire.CreatedAt, &questionnaire.UpdatedAt, &questionnaire.Topic, &questionnaire.Questions, &questionnaire.UserId)
+	err := row.Scan(&questionnaire.Id, &questionnaire.CreatedAt, &questionnaire.UpdatedAt, &questionnaire.Topic,
+		&questionnaire.Version, &questionnaire.Status, &questionnaire.OpensAt, &questionnaire.ClosesAt,
+		&questionnaire.Anonymous, &questionnaire.IsTemplate, &questionnaire.ResponsePolicy, &questionnaire.EditWindowMinutes, &questionnaire.UserId)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
 		return nil, fmt.Errorf("cannot retrive questionnaire with id: %v, %w", id, err)
 	}
 	return &questionnaire, nil
 }
 
+// Update saves the questionnaire. When questionnaire.Questions is not nil the existing questions
+// are replaced by it, otherwise they are left untouched. Changing the topic or the questions of
+// a questionnaire that has answers starts a new version instead of changing the answered one.
 func (q QuestionnaireModel) Update(questionnaire *Questionnaire) error {
-	// Update a specific menu item in the database.
 	query := `
 		UPDATE questionnaire
-		SET topic = $1, questions = $2, userId = $3, updatedAt = CURRENT_TIMESTAMP
-		WHERE id = $4 AND updatedAt = $5
-		RETURNING updatedAt
+		SET topic = $1, status = $2, opensAt = $3, closesAt = $4, anonymous = $5, isTemplate = $6,
+			responsePolicy = $7, editWindowMinutes = $8, userId = $9, updatedAt = CURRENT_TIMESTAMP
+		WHERE id = $10 AND updatedAt = $11
+		RETURNING updatedAt, version
 		`
-	args := []interface{}{questionnaire.Topic, questionnaire.Questions, questionnaire.UserId, questionnaire.Id, questionnaire.UpdatedAt}
+	args := []interface{}{questionnaire.Topic, questionnaire.Status, questionnaire.OpensAt, questionnaire.ClosesAt,
+		questionnaire.Anonymous, questionnaire.IsTemplate, questionnaire.ResponsePolicy, questionnaire.EditWindowMinutes,
+		questionnaire.UserId, questionnaire.Id, questionnaire.UpdatedAt}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return q.DB.QueryRowContext(ctx, query, args...).Scan(&questionnaire.UpdatedAt)
+	tx, err := q.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var topic string
+	err = tx.QueryRowContext(ctx, `SELECT topic FROM questionnaire WHERE id = $1 FOR UPDATE`, questionnaire.Id).Scan(&topic)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrEditConflict
+		}
+		return err
+	}
+
+	// Replaced questions don't have to be copied into the new version first.
+	if questionnaire.Questions != nil || questionnaire.Topic != topic {
+		_, err = forkVersion(ctx, tx, questionnaire.Id, questionnaire.Questions == nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&questionnaire.UpdatedAt, &questionnaire.Version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrEditConflict
+		}
+		return err
+	}
+
+	query = `
+		UPDATE questionnaire_version
+		SET topic = $1
+		WHERE questionnaireId = $2 AND version = $3
+		`
+
+	_, err = tx.ExecContext(ctx, query, questionnaire.Topic, questionnaire.Id, questionnaire.Version)
+	if err != nil {
+		return err
+	}
+
+	if questionnaire.Questions != nil {
+		err = replaceQuestions(ctx, tx, questionnaire.Id, questionnaire.Questions)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
 func (q QuestionnaireModel) Delete(id int) error {
@@ -137,6 +348,20 @@ func ValidateQuestionnaire(v *validator.Validator, questionnaire *Questionnaire)
 	v.Check(questionnaire.Topic != "", "topic", "must be provided")
 	// Check if the title field is not more than 100 characters.
 	v.Check(len(questionnaire.Topic) <= 100, "topic", "must not be more than 100 bytes long")
-	// Check if the description field is not more than 1000 characters.
-	v.Check(len(questionnaire.Questions) <= 1000, "questions", "must not be more than 1000 bytes long")
+	v.Check(validator.In(questionnaire.Status, StatusDraft, StatusPublished, StatusClosed), "status", "must be one of draft, published, closed")
+	v.Check(validator.In(questionnaire.ResponsePolicy, ResponsePolicySingle, ResponsePolicyMultiple), "responsePolicy", "must be single or multiple")
+	if questionnaire.EditWindowMinutes != nil {
+		v.Check(*questionnaire.EditWindowMinutes > 0, "editWindowMinutes", "must be greater than 0")
+		v.Check(*questionnaire.EditWindowMinutes <= 525_600, "editWindowMinutes", "must not be more than a year")
+	}
+	if questionnaire.OpensAt != nil && questionnaire.ClosesAt != nil {
+		v.Check(questionnaire.ClosesAt.After(*questionnaire.OpensAt), "closesAt", "must be after opensAt")
+	}
+	// Check every question, keying the errors by the question's index in the list.
+	v.Check(len(questionnaire.Questions) <= 200, "questions", "must not contain more than 200 questions")
+	for i, question := range questionnaire.Questions {
+		validateQuestion(v, fmt.Sprintf("questions[%d].", i), question)
+	}
+	// Conditions refer to questions by their position, which is their place in the list.
+	validateLogic(v, questionnaire.Questions)
 }
@@ -0,0 +1,195 @@
+package model
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Results holds the aggregated responses to a questionnaire.
+type Results struct {
+	QuestionnaireId int64             `json:"questionnaireId"`
+	Version         int               `json:"version"`
+	TotalResponses  int               `json:"totalResponses"`
+	Questions       []*QuestionResult `json:"questions"`
+}
+
+// QuestionResult aggregates the responses to a single question. Options is only set for choice
+// questions and Scale only for scale questions that received at least one response.
+type QuestionResult struct {
+	QuestionId    int64           `json:"questionId"`
+	Position      int             `json:"position"`
+	Type          string          `json:"type"`
+	Text          string          `json:"text"`
+	ResponseCount int             `json:"responseCount"`
+	Options       []*OptionResult `json:"options,omitempty"`
+	Scale         *ScaleStats     `json:"scale,omitempty"`
+}
+
+// OptionResult holds how many responses selected an option. Percentage is relative to the
+// number of responses to the question, so for multiple choice questions the percentages of all
+// options may add up to more than 100.
+type OptionResult struct {
+	OptionId   int64   `json:"optionId"`
+	Text       string  `json:"text"`
+	Count      int     `json:"count"`
+	Percentage float64 `json:"percentage"`
+}
+
+type ScaleStats struct {
+	Min    int     `json:"min"`
+	Max    int     `json:"max"`
+	Mean   float64 `json:"mean"`
+	Median float64 `json:"median"`
+	StdDev float64 `json:"stddev"`
+}
+
+// Results aggregates the answers given against one version of a questionnaire in the database,
+// so the cost of the request doesn't grow with the amount of data sent to the client. questions
+// are the questions of that version; responses to other versions never match them.
+func (a AnswerModel) Results(questionnaireID int64, version int, questions []*Question) (*Results, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	results := &Results{
+		QuestionnaireId: questionnaireID,
+		Version:         version,
+		Questions:       make([]*QuestionResult, 0, len(questions)),
+	}
+
+	byQuestion := make(map[int64]*QuestionResult, len(questions))
+	byOption := make(map[int64]*OptionResult)
+	for _, question := range questions {
+		result := &QuestionResult{
+			QuestionId: question.Id,
+			Position:   question.Position,
+			Type:       question.Type,
+			Text:       question.Text,
+		}
+		for _, option := range question.Options {
+			optionResult := &OptionResult{OptionId: option.Id, Text: option.Text}
+			result.Options = append(result.Options, optionResult)
+			byOption[option.Id] = optionResult
+		}
+		byQuestion[question.Id] = result
+		results.Questions = append(results.Questions, result)
+	}
+
+	query := `SELECT count(*) FROM answer WHERE questionnaireId = $1 AND version = $2`
+
+	err := a.DB.QueryRowContext(ctx, query, questionnaireID, version).Scan(&results.TotalResponses)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT answer_response.questionId, count(*)
+		FROM answer_response
+			INNER JOIN answer ON answer.id = answer_response.answerId
+		WHERE answer.questionnaireId = $1
+		GROUP BY answer_response.questionId
+		`
+
+	err = scanEach(ctx, a.DB, query, questionnaireID, func(scan func(...interface{}) error) error {
+		var questionID int64
+		var count int
+		if err := scan(&questionID, &count); err != nil {
+			return err
+		}
+		if result, ok := byQuestion[questionID]; ok {
+			result.ResponseCount = count
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT selected.optionId, count(*)
+		FROM answer_response
+			INNER JOIN answer ON answer.id = answer_response.answerId
+			CROSS JOIN unnest(answer_response.optionIds) AS selected (optionId)
+		WHERE answer.questionnaireId = $1
+		GROUP BY selected.optionId
+		`
+
+	err = scanEach(ctx, a.DB, query, questionnaireID, func(scan func(...interface{}) error) error {
+		var optionID int64
+		var count int
+		if err := scan(&optionID, &count); err != nil {
+			return err
+		}
+		if result, ok := byOption[optionID]; ok {
+			result.Count = count
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT answer_response.questionId,
+			min(answer_response.number),
+			max(answer_response.number),
+			avg(answer_response.number)::float8,
+			percentile_cont(0.5) WITHIN GROUP (ORDER BY answer_response.number),
+			coalesce(stddev_pop(answer_response.number), 0)::float8
+		FROM answer_response
+			INNER JOIN answer ON answer.id = answer_response.answerId
+		WHERE answer.questionnaireId = $1 AND answer_response.number IS NOT NULL
+		GROUP BY answer_response.questionId
+		`
+
+	err = scanEach(ctx, a.DB, query, questionnaireID, func(scan func(...interface{}) error) error {
+		var questionID int64
+		var stats ScaleStats
+		if err := scan(&questionID, &stats.Min, &stats.Max, &stats.Mean, &stats.Median, &stats.StdDev); err != nil {
+			return err
+		}
+		if result, ok := byQuestion[questionID]; ok && result.Type == QuestionScale {
+			stats.Mean = round(stats.Mean, 2)
+			stats.StdDev = round(stats.StdDev, 2)
+			result.Scale = &stats
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, result := range results.Questions {
+		if result.ResponseCount == 0 {
+			continue
+		}
+		for _, option := range result.Options {
+			option.Percentage = round(float64(option.Count)*100/float64(result.ResponseCount), 2)
+		}
+	}
+
+	return results, nil
+}
+
+// scanEach runs a query and calls fn once for every row, passing it the row's Scan method.
+func scanEach(ctx context.Context, db querier, query string, arg interface{}, fn func(scan func(...interface{}) error) error) error {
+	rows, err := db.QueryContext(ctx, query, arg)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := fn(rows.Scan); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// round rounds x to the given number of decimal places.
+func round(x float64, places int) float64 {
+	pow := math.Pow(10, float64(places))
+	return math.Round(x*pow) / pow
+}
@@ -3,10 +3,12 @@ package model
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
 	"errors"
 	"github.com/Aminochka4/Golang/final-project/pkg/my-project/validator"
+	"github.com/lib/pq"
 	"log"
 	"time"
 )
@@ -14,14 +16,42 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopePasswordReset  = "password-reset"
+	ScopeEmailChange    = "email-change"
+	ScopeEmailRevert    = "email-revert"
+	ScopeRefresh        = "refresh"
+	ScopeTwoFactor      = "two-factor"
 )
 
+// ErrTokenReused is returned when a refresh token that was already rotated is used again. The
+// token has most likely been stolen, so its whole family has been revoked.
+var ErrTokenReused = errors.New("refresh token reused")
+
 type (
+	// Token is handed to the client as Plaintext. Only its SHA-256 Hash is stored, so the
+	// tokens table can't be used to impersonate anyone.
 	Token struct {
+		Id        int64     `json:"-"`
 		Plaintext string    `json:"token"`
+		Hash      []byte    `json:"-"`
 		UserID    int64     `json:"-"`
 		Expiry    time.Time `json:"expiry"`
 		Scope     string    `json:"-"`
+		UserAgent string    `json:"-"`
+		IP        string    `json:"-"`
+		FamilyId  *int64    `json:"-"`
+	}
+
+	// Session describes an authentication token to its user, without the token itself.
+	// Current is set for the token the request listing the sessions was made with.
+	Session struct {
+		Id         int64      `json:"id"`
+		CreatedAt  time.Time  `json:"createdAt"`
+		LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+		Expiry     time.Time  `json:"expiry"`
+		UserAgent  string     `json:"userAgent"`
+		IP         string     `json:"ip"`
+		Current    bool       `json:"current"`
 	}
 
 	TokenModel struct {
@@ -31,70 +61,368 @@ type (
 	}
 )
 
-func (m TokenModel) Parse(tokenString string) (*Token, error) {
-	// Напишите SQL-запрос для поиска токена по его строковому представлению
+func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(token)
+	return token, err
+
+}
+
+// NewSession starts a session for a client: a short-lived authentication token, and a refresh
+// token that the client can trade for new ones. Both start a new token family.
+func (m TokenModel) NewSession(userID int64, accessTTL, refreshTTL time.Duration, userAgent, ip string) (access, refresh *Token, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	refresh, err = generateToken(userID, refreshTTL, ScopeRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refresh.UserAgent = userAgent
+	refresh.IP = ip
+
+	id, err := insertToken(ctx, tx, refresh)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refresh.FamilyId = &id
+
+	_, err = tx.ExecContext(ctx, `UPDATE tokens SET familyId = id WHERE id = $1`, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	access, err = insertAccessToken(ctx, tx, refresh, accessTTL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return access, refresh, tx.Commit()
+}
+
+// Refresh rotates a refresh token: it is marked as used, the authentication token issued with
+// it is revoked, and a new pair is returned in their place. The new refresh token gets a full
+// refreshTTL again, so sessions that are used regularly never expire. Using a refresh token a
+// second time revokes its whole family and returns ErrTokenReused.
+func (m TokenModel) Refresh(plaintext string, accessTTL, refreshTTL time.Duration, userAgent, ip string) (access, refresh *Token, err error) {
 	query := `
-		SELECT plaintext, user_id, expiry, scope
+		SELECT user_id, familyId, rotatedAt IS NOT NULL
 		FROM tokens
-		WHERE plaintext = $1
-	`
+		WHERE hash = $1 AND scope = $2 AND expiry > NOW()
+		FOR UPDATE
+		`
 
-	// Выполните SQL-запрос и получите результат
-	var token Token
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	err := m.DB.QueryRowContext(ctx, query, tokenString).Scan(&token.Plaintext, &token.UserID, &token.Expiry, &token.Scope)
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	var userID, familyID int64
+	var rotated bool
+	err = tx.QueryRowContext(ctx, query, hashToken(plaintext), ScopeRefresh).Scan(&userID, &familyID, &rotated)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, ErrRecordNotFound
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
 		}
-		return nil, err
 	}
 
-	// Установите строковое представление токена
-	if token.Plaintext == tokenString {
-		return &token, nil
+	if rotated {
+		_, err = tx.ExecContext(ctx, revoke(`DELETE FROM tokens WHERE familyId = $1`), familyID)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if err = tx.Commit(); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, ErrTokenReused
 	}
 
-	return nil, ErrRecordNotFound
+	_, err = tx.ExecContext(ctx, `UPDATE tokens SET rotatedAt = NOW() WHERE hash = $1`, hashToken(plaintext))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, revoke(`DELETE FROM tokens WHERE familyId = $1 AND scope = $2`), familyID, ScopeAuthentication)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refresh, err = generateToken(userID, refreshTTL, ScopeRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refresh.UserAgent = userAgent
+	refresh.IP = ip
+	refresh.FamilyId = &familyID
+
+	_, err = insertToken(ctx, tx, refresh)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	access, err = insertAccessToken(ctx, tx, refresh, accessTTL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return access, refresh, tx.Commit()
 }
 
-func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
-	token, err := generateToken(userID, ttl, scope)
+// insertAccessToken issues an authentication token in the family of a refresh token.
+func insertAccessToken(ctx context.Context, db querier, refresh *Token, ttl time.Duration) (*Token, error) {
+	access, err := generateToken(refresh.UserID, ttl, ScopeAuthentication)
 	if err != nil {
 		return nil, err
 	}
 
-	err = m.Insert(token)
-	return token, err
+	access.UserAgent = refresh.UserAgent
+	access.IP = refresh.IP
+	access.FamilyId = refresh.FamilyId
 
+	_, err = insertToken(ctx, db, access)
+	return access, err
 }
 
 func (m TokenModel) Insert(token *Token) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := insertToken(ctx, m.DB, token)
+	return err
+}
+
+func insertToken(ctx context.Context, db querier, token *Token) (int64, error) {
 	query := `
-		INSERT INTO tokens (plaintext, user_id, expiry, scope)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO tokens (hash, user_id, expiry, scope, userAgent, ip, familyId)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id
 		`
 
-	args := []interface{}{token.Plaintext, token.UserID, token.Expiry, token.Scope}
+	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope, token.UserAgent, token.IP, token.FamilyId}
+
+	err := db.QueryRowContext(ctx, query, args...).Scan(&token.Id)
+	return token.Id, err
+}
 
+// revoke wraps a DELETE on tokens that returns the deleted rows, so that the authentication
+// tokens among them are added to the denylist. Signed tokens of those sessions are refused from
+// then on, even though they are never looked up in the tokens table.
+func revoke(deleteQuery string) string {
+	return `
+		WITH deleted AS (` + deleteQuery + ` RETURNING id, scope, expiry)
+		INSERT INTO token_denylist (sessionId, expiry)
+		SELECT id, expiry FROM deleted WHERE scope = '` + ScopeAuthentication + `'
+		ON CONFLICT DO NOTHING
+		`
+}
+
+// Seal replaces the plaintext of a token that was just issued, e.g. with a signed token that
+// wraps it, so that the token is known by the plaintext the client actually holds.
+func (m TokenModel) Seal(token *Token, plaintext string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, args...)
+	hash := hashToken(plaintext)
+
+	_, err := m.DB.ExecContext(ctx, `UPDATE tokens SET hash = $1 WHERE id = $2`, hash, token.Id)
+	if err != nil {
+		return err
+	}
+
+	token.Plaintext = plaintext
+	token.Hash = hash
+	return nil
+}
+
+// Denylist returns the ids of the revoked sessions whose tokens haven't expired yet. Entries
+// that have are dropped along the way.
+func (m TokenModel) Denylist() (map[int64]bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM token_denylist WHERE expiry <= NOW()`)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := m.DB.QueryContext(ctx, `SELECT sessionId FROM token_denylist`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	denied := make(map[int64]bool)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		denied[id] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return denied, nil
+}
+
+// Touch records that the authentication token with the given session id was just used. Opaque
+// tokens are touched by UserModel.GetForToken; signed tokens aren't looked up, so their use is
+// recorded with this instead.
+func (m TokenModel) Touch(session int64) error {
+	query := `
+		UPDATE tokens
+		SET lastUsedAt = NOW()
+		WHERE id = $1 AND scope = $2
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, session, ScopeAuthentication)
 	return err
 }
 
-func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
+// GetSessions returns the unexpired authentication tokens of a user, most recently used first.
+// currentPlaintext is the token the request was made with, which is marked as current.
+func (m TokenModel) GetSessions(userID int64, currentPlaintext string) ([]*Session, error) {
 	query := `
-		DELETE FROM tokens
-		WHERE scope = $1 AND user_id = $2
+		SELECT id, createdAt, lastUsedAt, expiry, userAgent, ip, hash = $3
+		FROM tokens
+		WHERE user_id = $1 AND scope = $2 AND expiry > NOW()
+		ORDER BY COALESCE(lastUsedAt, createdAt) DESC, id DESC
 		`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, scope, userID)
+	rows, err := m.DB.QueryContext(ctx, query, userID, ScopeAuthentication, hashToken(currentPlaintext))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(&session.Id, &session.CreatedAt, &session.LastUsedAt, &session.Expiry,
+			&session.UserAgent, &session.IP, &session.Current)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, &session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// Delete revokes a single token, whatever its scope. Revoking an authentication token also
+// revokes the refresh tokens of its family, so that the session can't be refreshed.
+func (m TokenModel) Delete(plaintext string) error {
+	query := revoke(`
+		DELETE FROM tokens
+		WHERE hash = $1 OR familyId = (SELECT familyId FROM tokens WHERE hash = $1)
+		`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, hashToken(plaintext))
+	return err
+}
+
+// DeleteSession revokes one of the authentication tokens of a user by its session id, along
+// with the rest of its family.
+func (m TokenModel) DeleteSession(userID, id int64) error {
+	query := revoke(`
+		DELETE FROM tokens
+		WHERE user_id = $2 AND (
+			(id = $1 AND scope = $3)
+			OR familyId = (SELECT familyId FROM tokens WHERE id = $1 AND user_id = $2 AND scope = $3)
+		)
+		`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID, ScopeAuthentication)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteOtherSessions revokes every authentication and refresh token of a user except the
+// token given and the rest of its family. Tokens issued before families were introduced have
+// none, so for them only the token itself is kept.
+func (m TokenModel) DeleteOtherSessions(userID int64, currentPlaintext string) error {
+	query := revoke(`
+		DELETE FROM tokens
+		WHERE user_id = $1 AND scope IN ($2, $3)
+			AND NOT (hash = $4 OR (familyId IS NOT NULL AND familyId = (SELECT familyId FROM tokens WHERE hash = $4)))
+		`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, ScopeAuthentication, ScopeRefresh, hashToken(currentPlaintext))
+	return err
+}
+
+// DeleteAllForUser revokes every token of a user with the given scope. Revoking the
+// authentication tokens logs the user out everywhere, so their refresh tokens are revoked too
+// and the signed tokens of those sessions are denylisted.
+func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
+	scopes := []string{scope}
+	if scope == ScopeAuthentication {
+		scopes = append(scopes, ScopeRefresh)
+	}
+
+	query := revoke(`
+		DELETE FROM tokens
+		WHERE scope = ANY($1) AND user_id = $2
+		`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, pq.Array(scopes), userID)
 	return err
 }
 
@@ -113,10 +441,17 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 	}
 
 	token.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	token.Hash = hashToken(token.Plaintext)
 
 	return token, nil
 }
 
+// hashToken returns the SHA-256 hash under which a token is stored.
+func hashToken(plaintext string) []byte {
+	hash := sha256.Sum256([]byte(plaintext))
+	return hash[:]
+}
+
 func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 	v.Check(tokenPlaintext != "", "token", "must be provided")
 	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
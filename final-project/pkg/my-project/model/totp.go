@@ -0,0 +1,227 @@
+package model
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"log"
+	"strings"
+	"time"
+)
+
+// ErrAlreadyEnrolled is returned when a user who already confirmed an authenticator enrolls
+// another one without disabling the first.
+var ErrAlreadyEnrolled = errors.New("two-factor authentication already enabled")
+
+// TOTP is the authenticator a user enrolled for two-factor authentication.
+type TOTP struct {
+	UserId      int64
+	Secret      string
+	ConfirmedAt *time.Time
+	LastStep    int64
+}
+
+// Enabled reports whether the enrollment was confirmed, so that logins need a code.
+func (t *TOTP) Enabled() bool {
+	return t != nil && t.ConfirmedAt != nil
+}
+
+type TOTPModel struct {
+	DB       *sql.DB
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+}
+
+// Get returns the authenticator of a user, or ErrRecordNotFound if they haven't enrolled one.
+func (m TOTPModel) Get(userID int64) (*TOTP, error) {
+	query := `
+		SELECT userId, secret, confirmedAt, lastStep
+		FROM user_totp
+		WHERE userId = $1
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var totp TOTP
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&totp.UserId, &totp.Secret, &totp.ConfirmedAt, &totp.LastStep)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &totp, nil
+}
+
+// Enroll stores a new, unconfirmed secret for a user, replacing an enrollment that was never
+// confirmed.
+func (m TOTPModel) Enroll(userID int64, secret string) error {
+	query := `
+		INSERT INTO user_totp (userId, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (userId) DO UPDATE
+			SET secret = EXCLUDED.secret, createdAt = NOW(), lastStep = 0
+			WHERE user_totp.confirmedAt IS NULL
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrAlreadyEnrolled
+	}
+
+	return nil
+}
+
+// Confirm enables two-factor authentication for a user whose code for step was accepted, and
+// returns a fresh set of recovery codes. The codes are only stored hashed, so this is the only
+// time they can be shown.
+func (m TOTPModel) Confirm(userID, step int64) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE user_totp
+		SET confirmedAt = NOW(), lastStep = $2
+		WHERE userId = $1 AND confirmedAt IS NULL
+		`
+
+	result, err := tx.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return nil, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if affected == 0 {
+		return nil, ErrAlreadyEnrolled
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE userId = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 10)
+	for i := range codes {
+		codes[i], err = generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.ExecContext(ctx, `INSERT INTO recovery_codes (userId, codeHash) VALUES ($1, $2)`,
+			userID, hashToken(normalizeRecoveryCode(codes[i])))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return codes, tx.Commit()
+}
+
+// UseStep records that a code for step was accepted. It returns false if a code for that step
+// or a later one was accepted before, in which case the code must be refused as a replay.
+func (m TOTPModel) UseStep(userID, step int64) (bool, error) {
+	query := `
+		UPDATE user_totp
+		SET lastStep = $2
+		WHERE userId = $1 AND lastStep < $2
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	return affected == 1, err
+}
+
+// UseRecoveryCode spends one of a user's recovery codes. It returns false if the code is not
+// one of theirs or was already used.
+func (m TOTPModel) UseRecoveryCode(userID int64, code string) (bool, error) {
+	query := `
+		DELETE FROM recovery_codes
+		WHERE userId = $1 AND codeHash = $2
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	return affected == 1, err
+}
+
+// Delete disables two-factor authentication for a user, along with their recovery codes.
+func (m TOTPModel) Delete(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE userId = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM user_totp WHERE userId = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// generateRecoveryCode returns a code such as "k3jd9-x8q2m".
+func generateRecoveryCode() (string, error) {
+	randomBytes := make([]byte, 7)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+
+	code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+// normalizeRecoveryCode ignores the case and separators of a recovery code as it is typed in.
+func normalizeRecoveryCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(code))
+}
@@ -7,11 +7,13 @@ import (
 	"github.com/Aminochka4/Golang/final-project/pkg/my-project/validator"
 	"golang.org/x/crypto/bcrypt"
 	"log"
+	"strings"
 	"time"
 )
 
 var (
-	ErrDuplicateEmail = errors.New("duplicate email")
+	ErrDuplicateEmail    = errors.New("duplicate email")
+	ErrDuplicateUsername = errors.New("duplicate username")
 )
 
 var AnonymousUser = &User{}
@@ -25,7 +27,11 @@ type User struct {
 	Email     string    `json:"email"`
 	Password  password  `json:"-"`
 	Activated bool      `json:"activated"`
-	Version   int       `json:"-"`
+	Version   int       `json:"version"`
+
+	// Who can see the surname and email on the user's public profile.
+	SurnameVisibility string `json:"surnameVisibility"`
+	EmailVisibility   string `json:"emailVisibility"`
 }
 
 func (u *User) IsAnonymous() bool {
@@ -71,29 +77,47 @@ func (u UserModel) Insert(user *User) error {
 	query := `
 			INSERT INTO users (name, surname, username, email, password, activated)
 			VALUES($1, $2, $3, $4, $5, $6)
-			RETURNING id, createdAt, version
+			RETURNING id, createdAt, version, surnameVisibility, emailVisibility
 			`
 	args := []interface{}{user.Name, user.Surname, user.Username, user.Email, user.Password.hash, user.Activated}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	tx, err := u.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	pqErr := `pq: duplicate key value violates unique constraint "users_email_key"`
-	err := u.DB.QueryRowContext(ctx, query, args...).Scan(&user.Id, &user.CreatedAt, &user.Version)
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&user.Id, &user.CreatedAt, &user.Version,
+		&user.SurnameVisibility, &user.EmailVisibility)
 	if err != nil {
 		switch {
 		case err.Error() == pqErr:
 			return ErrDuplicateEmail
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_username_key"`:
+			return ErrDuplicateUsername
 		default:
 			return err
 		}
 	}
 
-	return nil
+	// Every user starts out as a member. The role is granted in the same transaction, so that
+	// there is never a user without it.
+	_, err = tx.ExecContext(ctx, `INSERT INTO users_roles SELECT $1, roles.id FROM roles WHERE roles.code = $2`,
+		user.Id, RoleMember)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func (u UserModel) GetAll() ([]*User, error) {
 	query := `
-		SELECT id, createdAt, name, surname, username, email, password, activated, version
+		SELECT id, createdAt, name, surname, username, email, password, activated, version, surnameVisibility,
+			emailVisibility
 		FROM users
 		ORDER BY id
 	`
@@ -108,7 +132,8 @@ func (u UserModel) GetAll() ([]*User, error) {
 	for rows.Next() {
 		var user User
 		err := rows.Scan(&user.Id, &user.CreatedAt, &user.Name, &user.Surname,
-			&user.Username, &user.Email, &user.Password.hash, &user.Activated, &user.Version)
+			&user.Username, &user.Email, &user.Password.hash, &user.Activated, &user.Version,
+			&user.SurnameVisibility, &user.EmailVisibility)
 		if err != nil {
 			return nil, err
 		}
@@ -124,7 +149,8 @@ func (u UserModel) GetAll() ([]*User, error) {
 
 func (u UserModel) GetById(id int) (*User, error) {
 	query := `
-		SELECT id, createdAt, name, surname, username, email, password, activated, version
+		SELECT id, createdAt, name, surname, username, email, password, activated, version, surnameVisibility,
+			emailVisibility
 		FROM users
 		WHERE id = $1
 		`
@@ -134,7 +160,8 @@ func (u UserModel) GetById(id int) (*User, error) {
 
 	row := u.DB.QueryRowContext(ctx, query, id)
 	err := row.Scan(&user.Id, &user.CreatedAt,
-		&user.Name, &user.Surname, &user.Username, &user.Email, &user.Password.hash, &user.Activated, &user.Version)
+		&user.Name, &user.Surname, &user.Username, &user.Email, &user.Password.hash, &user.Activated, &user.Version,
+		&user.SurnameVisibility, &user.EmailVisibility)
 
 	if err != nil {
 		switch {
@@ -151,12 +178,14 @@ func (u UserModel) GetById(id int) (*User, error) {
 func (u UserModel) Update(user *User) error {
 	query := `
 		UPDATE users
-		SET  name = $1, surname = $2, username = $3, email = $4, password = $5, activated = $6, version = version + 1
-		WHERE id = $7 AND version = $8
+		SET  name = $1, surname = $2, username = $3, email = $4, password = $5, activated = $6,
+			surnameVisibility = $7, emailVisibility = $8, version = version + 1
+		WHERE id = $9 AND version = $10
 		RETURNING version
 		`
 
-	args := []interface{}{user.Name, user.Surname, user.Username, user.Email, user.Password.hash, user.Activated, user.Id, user.Version}
+	args := []interface{}{user.Name, user.Surname, user.Username, user.Email, user.Password.hash, user.Activated,
+		user.SurnameVisibility, user.EmailVisibility, user.Id, user.Version}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
@@ -165,6 +194,8 @@ func (u UserModel) Update(user *User) error {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
 			return ErrDuplicateEmail
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_username_key"`:
+			return ErrDuplicateUsername
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
 		default:
@@ -190,21 +221,30 @@ func (u UserModel) Delete(id int) error {
 	return err
 }
 
+// GetForToken returns the user a token of the given scope was issued to, as long as the token
+// hasn't expired. It is the only way tokens are looked up, and records when the token was last
+// used.
 func (u UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
 
 	query := `
+		WITH token AS (
+			UPDATE tokens
+			SET lastUsedAt = NOW()
+			WHERE hash = $1
+				AND scope = $2
+				AND expiry > $3
+			RETURNING user_id
+		)
 		SELECT 
 			users.id, users.createdAt, users.name, users.surname, users.username, 
-			users.email, users.password, users.activated, users.version
+			users.email, users.password, users.activated, users.version, users.surnameVisibility,
+			users.emailVisibility
 		FROM	users
-        INNER JOIN tokens
-			ON users.id = tokens.user_id
-        WHERE tokens.plaintext = $1 
-			AND tokens.scope = $2
-			AND tokens.expiry > $3
+        INNER JOIN token
+			ON users.id = token.user_id
 		`
 
-	args := []interface{}{tokenPlaintext, tokenScope, time.Now()}
+	args := []interface{}{hashToken(tokenPlaintext), tokenScope, time.Now()}
 
 	var user User
 
@@ -221,6 +261,8 @@ func (u UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 		&user.Password.hash,
 		&user.Activated,
 		&user.Version,
+		&user.SurnameVisibility,
+		&user.EmailVisibility,
 	)
 	if err != nil {
 		switch {
@@ -245,11 +287,30 @@ func ValidatePasswordPlaintext(v *validator.Validator, password string) {
 	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
 }
 
+// NormalizeEmail returns the form email addresses are stored and looked up in.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func ValidateEmail(v *validator.Validator, email string) {
+	v.Check(email != "", "email", "must be provided")
+	v.Check(len(email) <= 254, "email", "must not be more than 254 bytes long")
+	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
+}
+
 func ValidateUser(v *validator.Validator, user *User) {
 	v.Check(user.Name != "", "name", "must be provided")
 	v.Check(len(user.Name) <= 500, "name", "must not be more than 500 bytes long")
+	v.Check(len(user.Surname) <= 500, "surname", "must not be more than 500 bytes long")
 
 	ValidateUsername(v, user.Username)
+	ValidateEmail(v, user.Email)
+
+	// A user that hasn't been inserted yet gets the defaults of the database.
+	if user.Id != 0 {
+		ValidateVisibility(v, "surnameVisibility", user.SurnameVisibility)
+		ValidateVisibility(v, "emailVisibility", user.EmailVisibility)
+	}
 
 	if user.Password.plaintext != nil {
 		ValidatePasswordPlaintext(v, *user.Password.plaintext)
@@ -263,7 +324,8 @@ func ValidateUser(v *validator.Validator, user *User) {
 
 func (u *UserModel) GetByUsername(username string) (*User, error) {
 	query := `
-        SELECT id, createdAt, name, surname, username, email, password, activated, version
+        SELECT id, createdAt, name, surname, username, email, password, activated, version, surnameVisibility,
+			emailVisibility
         FROM users
         WHERE username = $1
     `
@@ -283,6 +345,46 @@ func (u *UserModel) GetByUsername(username string) (*User, error) {
 		&user.Password.hash,
 		&user.Activated,
 		&user.Version,
+		&user.SurnameVisibility,
+		&user.EmailVisibility,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+func (u *UserModel) GetByEmail(email string) (*User, error) {
+	query := `
+        SELECT id, createdAt, name, surname, username, email, password, activated, version, surnameVisibility,
+			emailVisibility
+        FROM users
+        WHERE email = $1
+    `
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := u.DB.QueryRowContext(ctx, query, NormalizeEmail(email)).Scan(
+		&user.Id,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Surname,
+		&user.Username,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+		&user.SurnameVisibility,
+		&user.EmailVisibility,
 	)
 	if err != nil {
 		switch {
@@ -0,0 +1,340 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"time"
+)
+
+// QuestionnaireVersion is a snapshot of the topic and questions of a questionnaire. A new
+// version is started whenever a questionnaire that already has answers is edited, and every
+// answer records the version it was given against, so older versions never change.
+type QuestionnaireVersion struct {
+	QuestionnaireId int64       `json:"questionnaireId"`
+	Version         int         `json:"version"`
+	CreatedAt       string      `json:"createdAt"`
+	Topic           string      `json:"topic"`
+	Answers         int         `json:"answers"`
+	Questions       []*Question `json:"questions,omitempty"`
+}
+
+// Kinds of QuestionChange.
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeChanged = "changed"
+)
+
+// VersionDiff lists what changed between two versions of a questionnaire. Questions are matched
+// by position, so a question moved to another position shows up as changes at both of them.
+type VersionDiff struct {
+	QuestionnaireId int64             `json:"questionnaireId"`
+	From            int               `json:"from"`
+	To              int               `json:"to"`
+	Topic           *TopicChange      `json:"topic,omitempty"`
+	Questions       []*QuestionChange `json:"questions"`
+}
+
+type TopicChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// QuestionChange describes the difference at a single position. Fields names the fields of a
+// changed question that differ.
+type QuestionChange struct {
+	Position int       `json:"position"`
+	Change   string    `json:"change"`
+	Fields   []string  `json:"fields,omitempty"`
+	From     *Question `json:"from,omitempty"`
+	To       *Question `json:"to,omitempty"`
+}
+
+// GetVersions returns every version of a questionnaire, oldest first, with the number of
+// answers given against each of them.
+func (q QuestionnaireModel) GetVersions(questionnaireID int64) ([]*QuestionnaireVersion, error) {
+	query := `
+		SELECT questionnaire_version.questionnaireId, questionnaire_version.version,
+			questionnaire_version.createdAt, questionnaire_version.topic, count(answer.id)
+		FROM questionnaire_version
+			LEFT JOIN answer ON answer.questionnaireId = questionnaire_version.questionnaireId
+				AND answer.version = questionnaire_version.version
+		WHERE questionnaire_version.questionnaireId = $1
+		GROUP BY questionnaire_version.questionnaireId, questionnaire_version.version
+		ORDER BY questionnaire_version.version
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := q.DB.QueryContext(ctx, query, questionnaireID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []*QuestionnaireVersion{}
+	for rows.Next() {
+		var version QuestionnaireVersion
+		err := rows.Scan(&version.QuestionnaireId, &version.Version, &version.CreatedAt, &version.Topic, &version.Answers)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, &version)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return versions, nil
+}
+
+// GetVersion returns a single version of a questionnaire together with its questions.
+func (q QuestionnaireModel) GetVersion(questionnaireID int64, version int) (*QuestionnaireVersion, error) {
+	if version < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT questionnaireId, version, createdAt, topic, (
+			SELECT count(*) FROM answer
+			WHERE answer.questionnaireId = questionnaire_version.questionnaireId
+				AND answer.version = questionnaire_version.version
+		)
+		FROM questionnaire_version
+		WHERE questionnaireId = $1 AND version = $2
+		`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var snapshot QuestionnaireVersion
+	err := q.DB.QueryRowContext(ctx, query, questionnaireID, version).Scan(&snapshot.QuestionnaireId,
+		&snapshot.Version, &snapshot.CreatedAt, &snapshot.Topic, &snapshot.Answers)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	snapshot.Questions, err = getVersionQuestions(ctx, q.DB, questionnaireID, version)
+	if err != nil {
+		return nil, err
+	}
+
+	return &snapshot, nil
+}
+
+// DiffVersions compares two versions of the same questionnaire.
+func DiffVersions(from, to *QuestionnaireVersion) *VersionDiff {
+	diff := &VersionDiff{
+		QuestionnaireId: from.QuestionnaireId,
+		From:            from.Version,
+		To:              to.Version,
+		Questions:       []*QuestionChange{},
+	}
+
+	if from.Topic != to.Topic {
+		diff.Topic = &TopicChange{From: from.Topic, To: to.Topic}
+	}
+
+	for i := 0; i < max(len(from.Questions), len(to.Questions)); i++ {
+		var before, after *Question
+		if i < len(from.Questions) {
+			before = from.Questions[i]
+		}
+		if i < len(to.Questions) {
+			after = to.Questions[i]
+		}
+
+		change := &QuestionChange{Position: i + 1, From: before, To: after}
+
+		switch {
+		case before == nil:
+			change.Change = ChangeAdded
+		case after == nil:
+			change.Change = ChangeRemoved
+		default:
+			change.Fields = changedFields(before, after)
+			if len(change.Fields) == 0 {
+				continue
+			}
+			change.Change = ChangeChanged
+		}
+
+		diff.Questions = append(diff.Questions, change)
+	}
+
+	return diff
+}
+
+// changedFields returns the names of the fields that differ between two questions, ignoring
+// their ids, which are never the same across versions.
+func changedFields(a, b *Question) []string {
+	var fields []string
+
+	if a.Type != b.Type {
+		fields = append(fields, "type")
+	}
+	if a.Text != b.Text {
+		fields = append(fields, "text")
+	}
+	if a.Required != b.Required {
+		fields = append(fields, "required")
+	}
+	if !equalInt(a.ScaleMin, b.ScaleMin) {
+		fields = append(fields, "scaleMin")
+	}
+	if !equalInt(a.ScaleMax, b.ScaleMax) {
+		fields = append(fields, "scaleMax")
+	}
+	if !equalOptions(a.Options, b.Options) {
+		fields = append(fields, "options")
+	}
+	if !reflect.DeepEqual(a.Logic, b.Logic) {
+		fields = append(fields, "logic")
+	}
+
+	return fields
+}
+
+func equalInt(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func equalOptions(a, b []*Option) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Text != b[i].Text {
+			return false
+		}
+	}
+	return true
+}
+
+// versionFork maps the ids of the questions and options of a version to the ids of their
+// copies in the version that replaced it.
+type versionFork struct {
+	questions map[int64]int64
+	options   map[int64]int64
+}
+
+// questionID returns the id of the copy of a question. Ids that weren't copied are returned
+// unchanged, so that looking them up fails the way it would have without the fork.
+func (f *versionFork) questionID(id int64) int64 {
+	if f == nil {
+		return id
+	}
+	if copied, ok := f.questions[id]; ok {
+		return copied
+	}
+	return id
+}
+
+// apply points a question and its options at their copies. Options without an id are new and
+// are left alone.
+func (f *versionFork) apply(question *Question) {
+	if f == nil {
+		return
+	}
+
+	question.Id = f.questionID(question.Id)
+	for _, option := range question.Options {
+		if copied, ok := f.options[option.Id]; ok {
+			option.Id = copied
+		}
+	}
+}
+
+// forkVersion prepares the current version of a questionnaire for an edit. As long as nobody
+// answered that version it is edited in place, and nil is returned. Otherwise a new version is
+// started; with copyQuestions the questions are copied into it, so that the edit can be applied
+// to the copies, and the returned fork maps the old ids to the new ones.
+func forkVersion(ctx context.Context, db querier, questionnaireID int64, copyQuestions bool) (*versionFork, error) {
+	query := `
+		SELECT version, topic, EXISTS (
+			SELECT 1 FROM answer
+			WHERE answer.questionnaireId = questionnaire.id AND answer.version = questionnaire.version
+		)
+		FROM questionnaire
+		WHERE id = $1
+		FOR UPDATE
+		`
+
+	var version int
+	var topic string
+	var answered bool
+	err := db.QueryRowContext(ctx, query, questionnaireID).Scan(&version, &topic, &answered)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if !answered {
+		return nil, nil
+	}
+
+	var questions []*Question
+	if copyQuestions {
+		questions, err = getQuestions(ctx, db, questionnaireID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = db.ExecContext(ctx, `UPDATE questionnaire SET version = version + 1 WHERE id = $1`, questionnaireID)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		INSERT INTO questionnaire_version (questionnaireId, version, topic)
+		VALUES ($1, $2, $3)
+		`
+
+	_, err = db.ExecContext(ctx, query, questionnaireID, version+1, topic)
+	if err != nil {
+		return nil, err
+	}
+
+	fork := &versionFork{
+		questions: make(map[int64]int64, len(questions)),
+		options:   make(map[int64]int64),
+	}
+
+	for _, question := range questions {
+		questionID := question.Id
+		optionIDs := make([]int64, len(question.Options))
+		for i, option := range question.Options {
+			optionIDs[i] = option.Id
+		}
+
+		// insertQuestion adds the question to the version that was just started.
+		err = insertQuestion(ctx, db, question)
+		if err != nil {
+			return nil, err
+		}
+
+		fork.questions[questionID] = question.Id
+		for i, option := range question.Options {
+			fork.options[optionIDs[i]] = option.Id
+		}
+	}
+
+	return fork, nil
+}
@@ -3,8 +3,11 @@ package validator
 import "regexp"
 
 var (
-	// EmailRX is a regex for sanity checking the format of username addresses.
+	// EmailRX is a regex for sanity checking the format of email addresses.
 	// The regex pattern used is taken from  https://html.spec.whatwg.org/#valid-e-mail-address.
+	EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+
+	// UsernameRX is a regex for sanity checking the format of usernames.
 	UsernameRX = regexp.MustCompile("^[a-zA-Z0-9_-]{3,16}$")
 )
 
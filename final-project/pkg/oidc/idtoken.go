@@ -0,0 +1,204 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Claims are the claims of a verified ID token that are used to find or create the user.
+type Claims struct {
+	Subject           string `json:"sub"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	Name              string `json:"name"`
+	GivenName         string `json:"given_name"`
+	FamilyName        string `json:"family_name"`
+	PreferredUsername string `json:"preferred_username"`
+}
+
+type idTokenClaims struct {
+	Claims
+	Issuer   string   `json:"iss"`
+	Audience audience `json:"aud"`
+	Expiry   int64    `json:"exp"`
+	Nonce    string   `json:"nonce"`
+}
+
+// audience is either a single string or an array of them.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+// clockSkew is how far the provider's clock may be ahead of ours.
+const clockSkew = time.Minute
+
+// verify checks the signature, issuer, audience, expiry and nonce of an ID token.
+func (p *Provider) verify(ctx context.Context, raw, nonce string) (*Claims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed id_token", ErrRejected)
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: malformed id_token header", ErrRejected)
+	}
+	if header.Algorithm != "RS256" {
+		return nil, fmt.Errorf("%w: unsupported id_token algorithm %q", ErrRejected, header.Algorithm)
+	}
+
+	key, err := p.key(ctx, header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed id_token signature", ErrRejected)
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, fmt.Errorf("%w: invalid id_token signature", ErrRejected)
+	}
+
+	var claims idTokenClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: malformed id_token claims", ErrRejected)
+	}
+
+	switch {
+	case claims.Issuer != p.Issuer:
+		return nil, fmt.Errorf("%w: id_token issued by %q", ErrRejected, claims.Issuer)
+	case !claims.Audience.includes(p.ClientID):
+		return nil, fmt.Errorf("%w: id_token not issued for this client", ErrRejected)
+	case time.Now().Add(-clockSkew).Unix() >= claims.Expiry:
+		return nil, fmt.Errorf("%w: id_token expired", ErrRejected)
+	case subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1:
+		return nil, fmt.Errorf("%w: id_token nonce mismatch", ErrRejected)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: id_token without subject", ErrRejected)
+	}
+
+	return &claims.Claims, nil
+}
+
+func (a audience) includes(clientID string) bool {
+	for _, aud := range a {
+		if aud == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// key returns the provider's RSA key with the given id. The keys are fetched again when the id
+// is unknown, as providers rotate them.
+func (p *Provider) key(ctx context.Context, id string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	key, ok := p.keys[id]
+	p.mu.Unlock()
+
+	if !ok {
+		if err := p.fetchKeys(ctx); err != nil {
+			return nil, err
+		}
+
+		p.mu.Lock()
+		key, ok = p.keys[id]
+		p.mu.Unlock()
+
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown id_token key %q", ErrRejected, id)
+		}
+	}
+
+	return key, nil
+}
+
+func (p *Provider) fetchKeys(ctx context.Context) error {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.JWKSURI, nil)
+	if err != nil {
+		return err
+	}
+
+	var set struct {
+		Keys []struct {
+			KeyType string `json:"kty"`
+			KeyID   string `json:"kid"`
+			Use     string `json:"use"`
+			N       string `json:"n"`
+			E       string `json:"e"`
+		} `json:"keys"`
+	}
+
+	if err := p.do(req, &set); err != nil {
+		return err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.KeyType != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) > 4 {
+			continue
+		}
+
+		keys[k.KeyID] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+
+	return nil
+}
+
+func decodeSegment(segment string, dst interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, dst)
+}
@@ -0,0 +1,258 @@
+// Package oidc signs users in with OpenID Connect providers, using the authorization code flow
+// with PKCE. Providers are discovered through their /.well-known/openid-configuration document,
+// and ID tokens are verified against the provider's published keys. Only RS256 signed ID tokens
+// are supported, which every provider offers.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config describes a provider, as read from the providers file.
+type Config struct {
+	Name         string   `json:"name"`
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"clientId"`
+	ClientSecret string   `json:"clientSecret"`
+	RedirectURL  string   `json:"redirectUrl"`
+	Scopes       []string `json:"scopes"`
+}
+
+// Provider is a configured OpenID Connect provider. Its discovery document and keys are fetched
+// the first time they are needed and cached afterwards.
+type Provider struct {
+	Config
+
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *discovery
+	keys      map[string]*rsa.PublicKey
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// LoadProviders reads a JSON array of provider configurations from a file.
+func LoadProviders(path string) (map[string]*Provider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []Config
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("oidc: %s: %w", path, err)
+	}
+
+	providers := make(map[string]*Provider, len(configs))
+	for _, config := range configs {
+		if config.Name == "" || config.Issuer == "" || config.ClientID == "" || config.RedirectURL == "" {
+			return nil, fmt.Errorf("oidc: %s: every provider needs a name, issuer, clientId and redirectUrl", path)
+		}
+		if _, exists := providers[config.Name]; exists {
+			return nil, fmt.Errorf("oidc: %s: duplicate provider %q", path, config.Name)
+		}
+
+		providers[config.Name] = NewProvider(config, nil)
+	}
+
+	return providers, nil
+}
+
+// NewProvider returns a provider that makes its requests with client, or with a client with a
+// 10 second timeout if client is nil.
+func NewProvider(config Config, client *http.Client) *Provider {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+
+	return &Provider{Config: config, client: client}
+}
+
+// Login holds the secrets of a login in progress, which have to be kept until the provider
+// redirects back with the authorization code.
+type Login struct {
+	State        string
+	Nonce        string
+	CodeVerifier string
+}
+
+// NewLogin generates the secrets of a new login.
+func NewLogin() (*Login, error) {
+	var login Login
+	for _, s := range []*string{&login.State, &login.Nonce, &login.CodeVerifier} {
+		random, err := randomString()
+		if err != nil {
+			return nil, err
+		}
+		*s = random
+	}
+	return &login, nil
+}
+
+// AuthCodeURL returns the URL of the provider's login page for a login.
+func (p *Provider) AuthCodeURL(ctx context.Context, login *Login) (string, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(login.CodeVerifier))
+
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {p.RedirectURL},
+		"scope":                 {strings.Join(p.Scopes, " ")},
+		"state":                 {login.State},
+		"nonce":                 {login.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return d.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+// Exchange trades an authorization code for the ID token of the user who logged in, and
+// verifies it.
+func (p *Provider) Exchange(ctx context.Context, login *Login, code string) (*Claims, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"client_id":     {p.ClientID},
+		"code_verifier": {login.CodeVerifier},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	var response struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+
+	err = p.do(req, &response)
+	if err != nil && response.Error == "" {
+		return nil, err
+	}
+	if response.Error != "" {
+		return nil, fmt.Errorf("%w: %s %s", ErrRejected, response.Error, response.ErrorDescription)
+	}
+	if response.IDToken == "" {
+		return nil, fmt.Errorf("%w: no id_token in token response", ErrRejected)
+	}
+
+	return p.verify(ctx, response.IDToken, login.Nonce)
+}
+
+// ErrRejected is returned when the provider refuses a code or returns an ID token that doesn't
+// verify.
+var ErrRejected = errors.New("oidc: login rejected")
+
+func (p *Provider) discover(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	wellKnown := strings.TrimSuffix(p.Issuer, "/") + "/.well-known/openid-configuration"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, wellKnown, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var d discovery
+	if err := p.do(req, &d); err != nil {
+		return nil, err
+	}
+
+	// The issuer in the document must be the one configured, or tokens from another issuer
+	// could be accepted.
+	if d.Issuer != p.Issuer {
+		return nil, fmt.Errorf("oidc: %s: discovery document is for issuer %q", p.Name, d.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc: %s: incomplete discovery document", p.Name)
+	}
+
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+// do sends a request and decodes the JSON response into dst. Responses with an error status are
+// still decoded, as token endpoints describe errors in the body.
+func (p *Provider) do(req *http.Request, dst interface{}) error {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+
+	decodeErr := json.Unmarshal(body, dst)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: %s: %s returned %s", p.Name, req.URL.Redacted(), resp.Status)
+	}
+	if decodeErr != nil {
+		return fmt.Errorf("oidc: %s: %w", p.Name, decodeErr)
+	}
+
+	return nil
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,330 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testClientID = "questionnaires"
+	testCode     = "good-code"
+)
+
+// mockProvider is an OpenID Connect provider that serves discovery, JWKS and token endpoints.
+// Its token endpoint only accepts testCode with the code verifier of the last login page URL,
+// and responds with whatever idToken returns.
+type mockProvider struct {
+	server *httptest.Server
+
+	mu          sync.Mutex
+	keys        map[string]*rsa.PrivateKey
+	jwksFetches int
+	challenge   string
+	idToken     func() string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+
+	m := &mockProvider{keys: map[string]*rsa.PrivateKey{"k1": generateKey(t)}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{
+			"issuer":                 m.server.URL,
+			"authorization_endpoint": m.server.URL + "/authorize",
+			"token_endpoint":         m.server.URL + "/token",
+			"jwks_uri":               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		m.jwksFetches++
+
+		keys := []map[string]string{}
+		for kid, key := range m.keys {
+			keys = append(keys, map[string]string{
+				"kty": "RSA",
+				"kid": kid,
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"keys": keys})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		challenge := m.challenge
+		m.mu.Unlock()
+
+		verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("code") != testCode ||
+			base64.RawURLEncoding.EncodeToString(verifier[:]) != challenge {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]string{"id_token": m.idToken()})
+	})
+
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+
+	return m
+}
+
+func (m *mockProvider) provider() *Provider {
+	return NewProvider(Config{
+		Name:        "mock",
+		Issuer:      m.server.URL,
+		ClientID:    testClientID,
+		RedirectURL: "http://localhost/callback",
+	}, m.server.Client())
+}
+
+// login starts a login and records the code challenge of its login page URL, as the provider
+// would when the user is redirected there.
+func (m *mockProvider) login(t *testing.T, p *Provider) *Login {
+	t.Helper()
+
+	login, err := NewLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := p.AuthCodeURL(context.Background(), login)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authURL, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := authURL.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("state") != login.State ||
+		query.Get("nonce") != login.Nonce || query.Get("client_id") != testClientID {
+		t.Fatalf("unexpected login page URL %s", raw)
+	}
+
+	m.mu.Lock()
+	m.challenge = query.Get("code_challenge")
+	m.mu.Unlock()
+
+	return login
+}
+
+// fetches returns how many times the keys were fetched.
+func (m *mockProvider) fetches() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.jwksFetches
+}
+
+// claims returns the claims of a valid ID token for a login.
+func (m *mockProvider) claims(login *Login) map[string]interface{} {
+	return map[string]interface{}{
+		"iss":            m.server.URL,
+		"aud":            testClientID,
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"nonce":          login.Nonce,
+		"sub":            "user-1",
+		"email":          "alice@example.com",
+		"email_verified": true,
+	}
+}
+
+func TestExchange(t *testing.T) {
+	m := newMockProvider(t)
+	p := m.provider()
+	login := m.login(t, p)
+
+	m.idToken = func() string {
+		return signToken(t, m.keys["k1"], map[string]interface{}{"alg": "RS256", "kid": "k1"}, m.claims(login))
+	}
+
+	claims, err := p.Exchange(context.Background(), login, testCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.Subject != "user-1" || claims.Email != "alice@example.com" || !claims.EmailVerified {
+		t.Errorf("got claims %+v", claims)
+	}
+
+	// The provider rejects a code verifier that doesn't match the challenge.
+	_, err = p.Exchange(context.Background(), &Login{Nonce: login.Nonce, CodeVerifier: "wrong"}, testCode)
+	if !errors.Is(err, ErrRejected) {
+		t.Errorf("wrong code verifier: got %v, want ErrRejected", err)
+	}
+}
+
+func TestExchangeRejectsInvalidIDTokens(t *testing.T) {
+	otherKey := generateKey(t)
+
+	tests := []struct {
+		name   string
+		header map[string]interface{}
+		claims func(claims map[string]interface{})
+		key    func(m *mockProvider) *rsa.PrivateKey
+		tamper func(token string) string
+	}{
+		{
+			name: "signed with another key",
+			key:  func(m *mockProvider) *rsa.PrivateKey { return otherKey },
+		},
+		{
+			name: "tampered claims",
+			tamper: func(token string) string {
+				parts := strings.Split(token, ".")
+				claims, _ := json.Marshal(map[string]interface{}{"sub": "admin"})
+				parts[1] = base64.RawURLEncoding.EncodeToString(claims)
+				return strings.Join(parts, ".")
+			},
+		},
+		{name: "alg HS256", header: map[string]interface{}{"alg": "HS256", "kid": "k1"}},
+		{name: "alg none", header: map[string]interface{}{"alg": "none", "kid": "k1"}},
+		{name: "wrong issuer", claims: func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" }},
+		{name: "wrong audience", claims: func(c map[string]interface{}) { c["aud"] = []string{"someone-else"} }},
+		{name: "wrong nonce", claims: func(c map[string]interface{}) { c["nonce"] = "replayed" }},
+		{name: "expired", claims: func(c map[string]interface{}) { c["exp"] = time.Now().Add(-2 * clockSkew).Unix() }},
+		{name: "no subject", claims: func(c map[string]interface{}) { delete(c, "sub") }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockProvider(t)
+			p := m.provider()
+			login := m.login(t, p)
+
+			header := tt.header
+			if header == nil {
+				header = map[string]interface{}{"alg": "RS256", "kid": "k1"}
+			}
+			claims := m.claims(login)
+			if tt.claims != nil {
+				tt.claims(claims)
+			}
+			key := m.keys["k1"]
+			if tt.key != nil {
+				key = tt.key(m)
+			}
+
+			m.idToken = func() string {
+				token := signToken(t, key, header, claims)
+				if tt.tamper != nil {
+					token = tt.tamper(token)
+				}
+				return token
+			}
+
+			_, err := p.Exchange(context.Background(), login, testCode)
+			if !errors.Is(err, ErrRejected) {
+				t.Errorf("got %v, want ErrRejected", err)
+			}
+		})
+	}
+}
+
+func TestUnknownKeyIDRefetchesKeys(t *testing.T) {
+	m := newMockProvider(t)
+	p := m.provider()
+
+	exchange := func(kid string, key *rsa.PrivateKey) error {
+		login := m.login(t, p)
+		m.idToken = func() string {
+			return signToken(t, key, map[string]interface{}{"alg": "RS256", "kid": kid}, m.claims(login))
+		}
+		_, err := p.Exchange(context.Background(), login, testCode)
+		return err
+	}
+
+	if err := exchange("k1", m.keys["k1"]); err != nil {
+		t.Fatal(err)
+	}
+	if err := exchange("k1", m.keys["k1"]); err != nil {
+		t.Fatal(err)
+	}
+	if m.fetches() != 1 {
+		t.Fatalf("got %d JWKS fetches for a known key, want 1", m.fetches())
+	}
+
+	// The provider rotates to a new key, which is only found by fetching the keys again.
+	newKey := generateKey(t)
+	m.mu.Lock()
+	m.keys = map[string]*rsa.PrivateKey{"k2": newKey}
+	m.mu.Unlock()
+
+	if err := exchange("k2", newKey); err != nil {
+		t.Fatal(err)
+	}
+	if m.fetches() != 2 {
+		t.Fatalf("got %d JWKS fetches after rotation, want 2", m.fetches())
+	}
+
+	// A key the provider doesn't publish is rejected after looking for it once more.
+	if err := exchange("k3", generateKey(t)); !errors.Is(err, ErrRejected) {
+		t.Errorf("unknown key: got %v, want ErrRejected", err)
+	}
+	if m.fetches() != 3 {
+		t.Errorf("got %d JWKS fetches after an unknown key, want 3", m.fetches())
+	}
+}
+
+func generateKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// signToken returns a JWT with the header and claims signed with RS256 by key, whatever the
+// header says the algorithm is.
+func signToken(t *testing.T, key *rsa.PrivateKey, header, claims map[string]interface{}) string {
+	t.Helper()
+
+	encode := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	signingInput := encode(header) + "." + encode(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,194 @@
+package signedtoken
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	oldSecret = "old-secret-old-secret-old-secret"
+	newSecret = "new-secret-new-secret-new-secret"
+)
+
+func mustParseKeys(t *testing.T, spec string) *Keyring {
+	t.Helper()
+
+	k, err := ParseKeys(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func mustSign(t *testing.T, k *Keyring, claims Claims) string {
+	t.Helper()
+
+	token, err := k.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// withHeader replaces the header of a token, keeping its payload and signature.
+func withHeader(t *testing.T, token string, h map[string]string) string {
+	t.Helper()
+
+	data, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(token, ".")
+	parts[0] = base64.RawURLEncoding.EncodeToString(data)
+	return strings.Join(parts, ".")
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	claims := Claims{Subject: 42, Session: 7, Activated: true, IssuedAt: now.Unix(), Expiry: now.Add(15 * time.Minute).Unix()}
+
+	oldKeys := mustParseKeys(t, "2023b="+oldSecret)
+	// After rotation the new key signs and the old one still verifies.
+	rotated := mustParseKeys(t, "2024a="+newSecret+",2023b="+oldSecret)
+	// Once the old key is dropped, its tokens no longer verify.
+	newOnly := mustParseKeys(t, "2024a="+newSecret)
+
+	valid := mustSign(t, rotated, claims)
+	signedWithOld := mustSign(t, oldKeys, claims)
+
+	tests := []struct {
+		name    string
+		keys    *Keyring
+		token   string
+		now     time.Time
+		wantErr error
+	}{
+		{name: "valid", keys: rotated, token: valid, now: now},
+		{name: "signed with the old key after rotation", keys: rotated, token: signedWithOld, now: now},
+		{name: "signed with a dropped key", keys: newOnly, token: signedWithOld, now: now, wantErr: ErrInvalid},
+		{
+			name: "tampered payload",
+			keys: rotated,
+			token: func() string {
+				parts := strings.Split(valid, ".")
+				tampered := mustSign(t, rotated, Claims{Subject: 1, Expiry: claims.Expiry})
+				parts[1] = strings.Split(tampered, ".")[1]
+				return strings.Join(parts, ".")
+			}(),
+			now:     now,
+			wantErr: ErrInvalid,
+		},
+		{
+			name: "tampered signature",
+			keys: rotated,
+			token: func() string {
+				signature := []byte(valid[strings.LastIndex(valid, ".")+1:])
+				if signature[0] == 'A' {
+					signature[0] = 'B'
+				} else {
+					signature[0] = 'A'
+				}
+				return valid[:strings.LastIndex(valid, ".")+1] + string(signature)
+			}(),
+			now:     now,
+			wantErr: ErrInvalid,
+		},
+		{
+			name:    "alg none",
+			keys:    rotated,
+			token:   withHeader(t, valid, map[string]string{"alg": "none", "typ": "JWT", "kid": "2024a"}),
+			now:     now,
+			wantErr: ErrInvalid,
+		},
+		{
+			name: "alg none without signature",
+			keys: rotated,
+			token: func() string {
+				unsigned := withHeader(t, valid, map[string]string{"alg": "none", "typ": "JWT", "kid": "2024a"})
+				return unsigned[:strings.LastIndex(unsigned, ".")+1]
+			}(),
+			now:     now,
+			wantErr: ErrInvalid,
+		},
+		{
+			name:    "alg HS512",
+			keys:    rotated,
+			token:   withHeader(t, valid, map[string]string{"alg": "HS512", "typ": "JWT", "kid": "2024a"}),
+			now:     now,
+			wantErr: ErrInvalid,
+		},
+		{
+			name:    "unknown kid",
+			keys:    rotated,
+			token:   withHeader(t, valid, map[string]string{"alg": "HS256", "typ": "JWT", "kid": "2025a"}),
+			now:     now,
+			wantErr: ErrInvalid,
+		},
+		{name: "malformed", keys: rotated, token: "not.a-token", now: now, wantErr: ErrInvalid},
+		{name: "last second before expiry", keys: rotated, token: valid, now: time.Unix(claims.Expiry-1, 0)},
+		{name: "expired", keys: rotated, token: valid, now: time.Unix(claims.Expiry, 0), wantErr: ErrExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.keys.Verify(tt.token, tt.now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && *got != claims {
+				t.Errorf("got claims %+v, want %+v", *got, claims)
+			}
+		})
+	}
+}
+
+func TestSignUsesFirstKey(t *testing.T) {
+	rotated := mustParseKeys(t, "2024a="+newSecret+",2023b="+oldSecret)
+	token := mustSign(t, rotated, Claims{Subject: 1, Expiry: time.Now().Add(time.Minute).Unix()})
+
+	var h header
+	if err := decode(strings.Split(token, ".")[0], &h); err != nil {
+		t.Fatal(err)
+	}
+	if h.KeyID != "2024a" || h.Algorithm != "HS256" {
+		t.Errorf("got header %+v, want kid 2024a and alg HS256", h)
+	}
+
+	if !LooksSigned(token) {
+		t.Error("signed token not recognised")
+	}
+	if LooksSigned("ABCDEFGHIJKLMNOPQRSTUVWXYZ") {
+		t.Error("opaque token recognised as signed")
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		wantErr bool
+	}{
+		{name: "single key", spec: "a=" + newSecret},
+		{name: "several keys with spaces", spec: "a=" + newSecret + ", b=" + oldSecret},
+		{name: "short key", spec: "a=" + newSecret[:minKeyLength-1], wantErr: true},
+		{name: "short second key", spec: "a=" + newSecret + ",b=short", wantErr: true},
+		{name: "duplicate id", spec: "a=" + newSecret + ",a=" + oldSecret, wantErr: true},
+		{name: "missing id", spec: "=" + newSecret, wantErr: true},
+		{name: "missing secret", spec: "a", wantErr: true},
+		{name: "empty", spec: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseKeys(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
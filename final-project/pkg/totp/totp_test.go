@@ -0,0 +1,142 @@
+package totp
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 secret of the test vectors in RFC 6238 appendix B, base32 encoded.
+var rfcSecret = encoding.EncodeToString([]byte("12345678901234567890"))
+
+// TestCodeRFC6238 checks the SHA-1 test vectors of RFC 6238 appendix B. The RFC lists 8 digit
+// codes; 6 digit codes are their last 6 digits.
+func TestCodeRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		code, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != tt.code {
+			t.Errorf("time %d: got code %s, want %s", tt.unix, code, tt.code)
+		}
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+
+	codeAt := func(step int64) string {
+		code, err := Code(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	tests := []struct {
+		name   string
+		step   int64
+		skew   int64
+		wantOK bool
+	}{
+		{"current step", current, 0, true},
+		{"previous step without skew", current - 1, 0, false},
+		{"previous step at the edge of the window", current - 1, 1, true},
+		{"next step at the edge of the window", current + 1, 1, true},
+		{"two steps back, outside the window", current - 2, 1, false},
+		{"two steps ahead, outside the window", current + 2, 1, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(rfcSecret, codeAt(tt.step), now, tt.skew)
+			if ok != tt.wantOK {
+				t.Fatalf("got ok %v, want %v", ok, tt.wantOK)
+			}
+			if ok && step != tt.step {
+				t.Errorf("got step %d, want %d", step, tt.step)
+			}
+		})
+	}
+
+	// The first and last second of a step belong to it.
+	for _, at := range []time.Time{time.Unix(current*period, 0), time.Unix(current*period+period-1, 0)} {
+		if _, ok := Validate(rfcSecret, codeAt(current), at, 0); !ok {
+			t.Errorf("code of step %d not valid at %d", current, at.Unix())
+		}
+	}
+
+	if _, ok := Validate(rfcSecret, "050 471", now, 0); !ok {
+		t.Error("code with a space not accepted")
+	}
+	if _, ok := Validate(rfcSecret, "05047", now, 1); ok {
+		t.Error("code with 5 digits accepted")
+	}
+}
+
+func TestSecretRoundTrip(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := encoding.DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != 20 {
+		t.Errorf("got a %d byte secret, want 20", len(key))
+	}
+
+	uri, err := url.Parse(ProvisioningURI("Questionnaires", "alice@example.com", secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uri.Scheme != "otpauth" || uri.Host != "totp" {
+		t.Errorf("got URI %s, want otpauth://totp/...", uri)
+	}
+	if uri.Path != "/Questionnaires:alice@example.com" {
+		t.Errorf("got label %q", uri.Path)
+	}
+
+	query := uri.Query()
+	if query.Get("secret") != secret || query.Get("issuer") != "Questionnaires" ||
+		query.Get("algorithm") != "SHA1" || query.Get("digits") != "6" || query.Get("period") != "30" {
+		t.Errorf("got parameters %v", query)
+	}
+
+	// A code computed from the secret read back from the URI validates against the original.
+	now := time.Now()
+	code, err := Code(query.Get("secret"), Step(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := Validate(secret, code, now, 0); !ok {
+		t.Error("code from the provisioned secret not valid")
+	}
+
+	// Secrets typed in by hand may be in lower case.
+	lower, err := Code(strings.ToLower(secret), Step(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lower != code {
+		t.Errorf("lower case secret: got code %s, want %s", lower, code)
+	}
+}